@@ -0,0 +1,154 @@
+// Package encryption encrypts finalized recordings at rest with age (https://age-encryption.org),
+// so that only holders of a private key matching a configured public key may read them
+package encryption
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	Suffix     = ".age"
+	dataFolder = "data/"
+)
+
+func recipientsFor(namespace string) []age.Recipient {
+	if r, ok := recipients[namespace]; ok {
+		return r
+	}
+	return recipients[defaultRecipients]
+}
+
+// Enabled if public keys are configured for namespace
+func Enabled(namespace string) bool {
+	return len(recipientsFor(namespace)) > 0
+}
+
+// EncryptFile encrypts a file (path relative to data folder) of namespace and removes its plain
+// version, returning the encrypted file path. If encryption is not enabled, path is returned
+func EncryptFile(namespace, path string) (string, error) {
+	rs := recipientsFor(namespace)
+	if len(rs) == 0 {
+		return path, nil
+	}
+	encryptedPath := path + Suffix
+	if err := encrypt(dataFolder+path, dataFolder+encryptedPath, rs); err != nil {
+		return path, err
+	}
+	if err := os.Remove(dataFolder + path); err != nil {
+		return encryptedPath, err
+	}
+	return encryptedPath, nil
+}
+
+// dst is written then renamed, so that it is either missing or complete
+func encrypt(src, dst string, rs []age.Recipient) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst + ".tmp")
+		}
+	}()
+
+	w, err := age.Encrypt(out, rs...)
+	if err == nil {
+		_, err = io.Copy(w, in)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+func decrypt(src, dst string, identities []age.Identity) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return
+	}
+	out, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+// RunDecrypt is the decrypt command, decrypted files are written next to encrypted ones unless
+// an output folder is given
+func RunDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	identityFile := fs.String("identity", "", "file containing age private key(s), for instance generated by age-keygen")
+	out := fs.String("out", "", "output folder (defaults to encrypted files folder)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup decrypt -identity <key file> [options] <file"+Suffix+">...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*identityFile) == 0 || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing identity or file")
+	}
+
+	f, err := os.Open(*identityFile)
+	if err != nil {
+		return err
+	}
+	identities, err := age.ParseIdentities(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, src := range fs.Args() {
+		if !strings.HasSuffix(src, Suffix) {
+			return fmt.Errorf("not an encrypted file: %v", src)
+		}
+		dst := strings.TrimSuffix(src, Suffix)
+		if len(*out) > 0 {
+			dst = filepath.Join(*out, filepath.Base(dst))
+		}
+		if err := decrypt(src, dst, identities); err != nil {
+			return fmt.Errorf("%v: %w", src, err)
+		}
+		fmt.Fprintln(os.Stderr, dst)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package encryption
+
+import (
+	"os"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptFile(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients["ns"] = []age.Recipient{identity.Recipient()}
+	defer delete(recipients, "ns")
+
+	os.MkdirAll("data/ns", 0775)
+	os.WriteFile("data/ns/a-dry.mkv", []byte("recording"), 0664)
+
+	if path, err := EncryptFile("other", "ns/a-dry.mkv"); err != nil || path != "ns/a-dry.mkv" {
+		t.Errorf("file should be left as is if encryption is not enabled, got %v", path)
+	}
+	path, err := EncryptFile("ns", "ns/a-dry.mkv")
+	if err != nil || path != "ns/a-dry.mkv.age" {
+		t.Fatalf("unexpected encryption result: %v %v", path, err)
+	}
+	if _, err := os.Stat("data/ns/a-dry.mkv"); !os.IsNotExist(err) {
+		t.Error("plain file should be removed")
+	}
+
+	if err := decrypt("data/"+path, "decrypted.mkv", []age.Identity{identity}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("decrypted.mkv"); string(data) != "recording" {
+		t.Errorf("unexpected decrypted content: %v", string(data))
+	}
+	other, _ := age.GenerateX25519Identity()
+	if err := decrypt("data/"+path, "other.mkv", []age.Identity{other}); err == nil {
+		t.Error("decryption with another key should fail")
+	}
+}
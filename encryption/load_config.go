@@ -0,0 +1,48 @@
+package encryption
+
+import (
+	"filippo.io/age"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultRecipients = "default"
+
+type encryptionConfig struct {
+	Namespaces map[string][]string `yaml:"namespaces"`
+}
+
+var (
+	config     encryptionConfig
+	recipients = make(map[string][]age.Recipient) // per namespace
+)
+
+func init() {
+	f, err := helpers.Open("config/encryption.yml")
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	err = decoder.Decode(&config)
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+
+	for namespace, keys := range config.Namespaces {
+		parsed := []age.Recipient{}
+		for _, key := range keys {
+			r, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				log.Error().Str("context", "init").Str("namespace", namespace).Err(err).Msg("encryption_key_invalid")
+				continue
+			}
+			parsed = append(parsed, r)
+		}
+		if len(parsed) > 0 {
+			recipients[namespace] = parsed
+		}
+	}
+}
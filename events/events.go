@@ -0,0 +1,198 @@
+// Package events is an in-process publish/subscribe bus for room, peer and pipeline events
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Kind string
+
+const (
+	RoomCreated      Kind = "room_created"
+	PeerJoined       Kind = "peer_joined"
+	JoinRejected     Kind = "join_rejected"
+	TrackAdded       Kind = "track_added"
+	RoomStarted      Kind = "room_started"
+	RoomEnded        Kind = "room_ended"
+	RoomDeleted      Kind = "room_deleted"
+	PeerDisconnected Kind = "peer_disconnected"
+	FilesReady       Kind = "files_ready"
+	FilesUploaded    Kind = "files_uploaded"
+	PipelineStarted  Kind = "pipeline_started"
+	PipelineError    Kind = "pipeline_error"
+	PipelineDeleted  Kind = "pipeline_deleted"
+	// see gst watchdog
+	PipelineStalled   Kind = "pipeline_stalled"
+	PipelineRecovered Kind = "pipeline_recovered"
+)
+
+const subscriptionBuffer = 1024
+
+type Event struct {
+	Kind      Kind        `json:"kind"`
+	Time      time.Time   `json:"time"`
+	Namespace string      `json:"namespace"`
+	RoomId    string      `json:"roomId"`
+	UserId    string      `json:"userId,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// FilesData is the data of FilesReady events, paths are relative to data folder
+type FilesData struct {
+	Session  string              `json:"session"`  // room lifetime id, see RoomCreated
+	Files    map[string][]string `json:"files"`    // per user id
+	Complete bool                `json:"complete"` // false if some recordings may not be finalized
+	Manifest string              `json:"manifest,omitempty"`
+	Log      string              `json:"log,omitempty"`
+}
+
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	kinds   []Kind // empty means all
+	tracked bool
+}
+
+type bus struct {
+	sync.RWMutex
+	subscriptions map[*Subscription]bool
+}
+
+// events received by tracked subscriptions and not processed yet, see Drain
+type pendingCount struct {
+	sync.Mutex
+	count int
+}
+
+var (
+	busSingleton = &bus{subscriptions: make(map[*Subscription]bool)}
+	pending      = &pendingCount{}
+)
+
+func (p *pendingCount) add(delta int) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.count += delta
+}
+
+func (p *pendingCount) get() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.count
+}
+
+func (s *Subscription) accepts(kind Kind) bool {
+	if len(s.kinds) == 0 {
+		return true
+	}
+	for _, k := range s.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe to events of given kinds (or all events if none is given). Subscribers are expected
+// to read from C without blocking too long: events are dropped when a subscription buffer is full
+func Subscribe(kinds ...Kind) *Subscription {
+	busSingleton.Lock()
+	defer busSingleton.Unlock()
+
+	ch := make(chan Event, subscriptionBuffer)
+	s := &Subscription{C: ch, ch: ch, kinds: kinds}
+	busSingleton.subscriptions[s] = true
+	return s
+}
+
+// SubscribeTracked is like Subscribe, but events received on C are pending until the subscriber calls
+// Done for each of them (once processed), so that Drain waits for them
+func SubscribeTracked(kinds ...Kind) *Subscription {
+	s := Subscribe(kinds...)
+	s.tracked = true
+	return s
+}
+
+// Done marks an event received on a tracked subscription as processed
+func (s *Subscription) Done() {
+	if s.tracked {
+		pending.add(-1)
+	}
+}
+
+// Drain blocks until events received by tracked subscriptions have been processed, or timeout.
+// Processing may publish other events, that are waited for too
+func Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for pending.get() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		<-ticker.C
+	}
+	return true
+}
+
+// Unsubscribe closes C, events still buffered are discarded (and no longer pending if tracked)
+func (s *Subscription) Unsubscribe() {
+	busSingleton.Lock()
+	defer busSingleton.Unlock()
+
+	if busSingleton.subscriptions[s] {
+		delete(busSingleton.subscriptions, s)
+		// received here instead of counting them, so that a concurrent reader calling Done is not counted twice
+	discard:
+		for {
+			select {
+			case <-s.ch:
+				if s.tracked {
+					pending.add(-1)
+				}
+			default:
+				break discard
+			}
+		}
+		close(s.ch)
+	}
+}
+
+// Publish never blocks
+func Publish(kind Kind, namespace, roomId, userId string, data interface{}) {
+	e := Event{
+		Kind:      kind,
+		Time:      time.Now(),
+		Namespace: namespace,
+		RoomId:    roomId,
+		UserId:    userId,
+		Data:      data,
+	}
+
+	busSingleton.RLock()
+	defer busSingleton.RUnlock()
+
+	for s := range busSingleton.subscriptions {
+		if !s.accepts(kind) {
+			continue
+		}
+		// counted before being sent, since it may be processed right away
+		if s.tracked {
+			pending.add(1)
+		}
+		select {
+		case s.ch <- e:
+		default:
+			if s.tracked {
+				pending.add(-1)
+			}
+			log.Error().Str("context", "app").Str("namespace", namespace).Str("room", roomId).Str("event", string(kind)).Msg("event_dropped")
+		}
+	}
+}
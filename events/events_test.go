@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	all := Subscribe()
+	defer all.Unsubscribe()
+	started := Subscribe(RoomStarted)
+	defer started.Unsubscribe()
+
+	Publish(RoomCreated, "ns", "room", "user", nil)
+	Publish(RoomStarted, "ns", "room", "", nil)
+
+	if e := <-all.C; e.Kind != RoomCreated || e.UserId != "user" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := <-all.C; e.Kind != RoomStarted {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := <-started.C; e.Kind != RoomStarted {
+		t.Errorf("filtered subscription received: %+v", e)
+	}
+	select {
+	case e := <-started.C:
+		t.Errorf("filtered subscription received: %+v", e)
+	default:
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := SubscribeTracked(FilesReady)
+	defer s.Unsubscribe()
+
+	Publish(FilesReady, "ns", "room", "", nil)
+	if Drain(10 * time.Millisecond) {
+		t.Error("drain should time out while event is pending")
+	}
+	go func() {
+		<-s.C
+		time.Sleep(50 * time.Millisecond)
+		s.Done()
+	}()
+	if !Drain(time.Second) {
+		t.Error("drain should succeed once event is processed")
+	}
+}
+
+func TestUnsubscribePending(t *testing.T) {
+	s := SubscribeTracked(FilesReady)
+
+	Publish(FilesReady, "ns", "room", "", nil)
+	Publish(FilesReady, "ns", "room", "", nil)
+	s.Unsubscribe()
+	if !Drain(10 * time.Millisecond) {
+		t.Error("drain should succeed once tracked subscription is unsubscribed")
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("buffered events should be discarded")
+	}
+}
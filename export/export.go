@@ -0,0 +1,390 @@
+// Package export converts the recordings of a namespace into a BIDS-inspired dataset: one folder
+// per subject (user) and session (room), holding media files, fx events TSVs and JSON sidecars
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/recordings"
+	"github.com/creamlab/ducksoup/types"
+)
+
+const (
+	dataFolder = "data"
+	na         = "n/a"
+)
+
+var labelRegexp = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// Sidecar describes a media file
+type Sidecar struct {
+	Source             string                `json:"Source"` // relative to data folder
+	SHA256             string                `json:"SHA256,omitempty"`
+	Namespace          string                `json:"Namespace"`
+	RoomId             string                `json:"RoomId"`
+	UserId             string                `json:"UserId"`
+	Recording          string                `json:"Recording"` // dry or wet
+	Run                int                   `json:"Run"`       // connection count (reconnections start new runs)
+	Encrypted          bool                  `json:"Encrypted"`
+	RecordingStartTime time.Time             `json:"RecordingStartTime"`
+	RoomStartTime      time.Time             `json:"RoomStartTime"`
+	RoomDuration       int                   `json:"RoomDuration"`
+	VideoFormat        string                `json:"VideoFormat"`
+	RecordingMode      string                `json:"RecordingMode"`
+	Width              int                   `json:"Width"`
+	Height             int                   `json:"Height"`
+	FrameRate          int                   `json:"FrameRate"`
+	AudioFx            string                `json:"AudioFx"`
+	VideoFx            string                `json:"VideoFx"`
+	Tracks             []types.ManifestTrack `json:"Tracks"`
+}
+
+// one participation of a subject, in a room session
+type participation struct {
+	session *recordings.Session
+	user    types.ManifestUser
+}
+
+type exporter struct {
+	out  string
+	link bool
+	// subject label per user id
+	labels map[string]string
+}
+
+func label(id string) string {
+	l := labelRegexp.ReplaceAllString(id, "")
+	if len(l) == 0 {
+		return "x"
+	}
+	return l
+}
+
+func (e *exporter) subjectLabel(userId string) string {
+	if l, ok := e.labels[userId]; ok {
+		return l
+	}
+	l := label(userId)
+	// ensure labels are unique
+	taken := make(map[string]bool)
+	for _, existing := range e.labels {
+		taken[existing] = true
+	}
+	for i := 2; taken[l]; i++ {
+		l = label(userId) + strconv.Itoa(i)
+	}
+	e.labels[userId] = l
+	return l
+}
+
+func writeTSV(path string, header []string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Comma = '\t'
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0664)
+}
+
+func (e *exporter) copyFile(src, dst string) error {
+	os.Remove(dst)
+	if e.link {
+		return os.Link(src, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return na
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// media suffix given file kind (dry, wet, audio-dry...)
+func mediaSuffix(kind string) (recording, suffix string) {
+	parts := strings.SplitN(kind, "-", 2)
+	if len(parts) == 2 {
+		return parts[1], parts[0]
+	}
+	return kind, "av"
+}
+
+func checksum(m *types.Manifest, path string) string {
+	for _, f := range m.Files {
+		if f.Path == path {
+			return f.SHA256
+		}
+	}
+	return ""
+}
+
+// concatenated tells if the file a segment belongs to exists
+func concatenated(files []recordings.File, segment recordings.File) bool {
+	for _, f := range files {
+		if len(f.Segment) == 0 && f.User == segment.User && f.Connection == segment.Connection && f.Kind == segment.Kind && f.Time == segment.Time {
+			return true
+		}
+	}
+	return false
+}
+
+// exports one participation in sub-<label>/ses-<index>/
+func (e *exporter) exportParticipation(p participation, subject string, index int) error {
+	s, m := p.session, p.session.Manifest
+	session := fmt.Sprintf("%02d", index)
+	folder := filepath.Join(e.out, "sub-"+subject, "ses-"+session)
+	if err := os.MkdirAll(folder, 0775); err != nil {
+		return err
+	}
+	prefix := "sub-" + subject + "_ses-" + session
+
+	for run, c := range p.user.Connections {
+		runPrefix := fmt.Sprintf("%s_run-%d", prefix, run+1)
+
+		// fx events, onsets relative to recording start
+		rows := [][]string{}
+		for _, fx := range c.FxEvents {
+			onset := na
+			if !c.RecordingStartedAt.IsZero() {
+				onset = seconds(fx.Time.Sub(c.RecordingStartedAt))
+			}
+			rows = append(rows, []string{
+				onset,
+				seconds(time.Duration(fx.Duration) * time.Millisecond),
+				"fx_change",
+				fx.Name,
+				fx.Property,
+				fx.Value,
+			})
+		}
+		if err := writeTSV(filepath.Join(folder, runPrefix+"_events.tsv"), []string{"onset", "duration", "trial_type", "fx_name", "fx_property", "fx_value"}, rows); err != nil {
+			return err
+		}
+
+		for _, f := range s.Files {
+			if f.User != p.user.UserId || len(c.FilePrefix) == 0 || !strings.HasPrefix(filepath.Base(f.Name), c.FilePrefix+"-") {
+				continue
+			}
+			if f.Kind != "dry" && f.Kind != "wet" && !strings.HasPrefix(f.Kind, "audio-") && !strings.HasPrefix(f.Kind, "video-") {
+				continue
+			}
+			// segments are only exported if they have not been concatenated (see repair command)
+			if len(f.Segment) > 0 && concatenated(s.Files, f) {
+				continue
+			}
+			recording, suffix := mediaSuffix(f.Kind)
+			name := strings.TrimSuffix(filepath.Base(f.Name), encryption.Suffix)
+			mediaPrefix := fmt.Sprintf("%s_rec-%s_run-%d_%s", prefix, recording, run+1, suffix)
+			if len(f.Segment) > 0 {
+				index, _ := strconv.Atoi(f.Segment)
+				mediaPrefix = fmt.Sprintf("%s_rec-%s_run-%d_split-%d_%s", prefix, recording, run+1, index+1, suffix)
+			}
+			ext := filepath.Ext(name)
+			if f.Encrypted {
+				ext += encryption.Suffix
+			}
+			if err := e.copyFile(filepath.Join(dataFolder, s.Namespace, f.Name), filepath.Join(folder, mediaPrefix+ext)); err != nil {
+				return err
+			}
+			source := s.Namespace + "/" + f.Name
+			sidecar := Sidecar{
+				Source:             source,
+				SHA256:             checksum(m, source),
+				Namespace:          s.Namespace,
+				RoomId:             m.RoomId,
+				UserId:             p.user.UserId,
+				Recording:          recording,
+				Run:                run + 1,
+				Encrypted:          f.Encrypted,
+				RecordingStartTime: c.RecordingStartedAt,
+				RoomStartTime:      m.StartedAt,
+				RoomDuration:       m.Duration,
+				VideoFormat:        c.Join.VideoFormat,
+				RecordingMode:      c.Join.RecordingMode,
+				Width:              c.Join.Width,
+				Height:             c.Join.Height,
+				FrameRate:          c.Join.FrameRate,
+				AudioFx:            c.Join.AudioFx,
+				VideoFx:            c.Join.VideoFx,
+				Tracks:             c.Tracks,
+			}
+			if err := writeJSON(filepath.Join(folder, mediaPrefix+".json"), sidecar); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// room composite recordings are not related to a subject, they are exported as derivatives
+func (e *exporter) exportComposites(sessions []*recordings.Session) error {
+	folder := filepath.Join(e.out, "derivatives", "room")
+	for _, s := range sessions {
+		if s.Manifest == nil {
+			continue
+		}
+		for _, f := range s.Files {
+			if f.Kind != "room" {
+				continue
+			}
+			if err := os.MkdirAll(folder, 0775); err != nil {
+				return err
+			}
+			name := strings.TrimSuffix(filepath.Base(f.Name), encryption.Suffix)
+			ext := filepath.Ext(name)
+			if f.Encrypted {
+				ext += encryption.Suffix
+			}
+			if err := e.copyFile(filepath.Join(dataFolder, s.Namespace, f.Name), filepath.Join(folder, s.Id+"_room"+ext)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Export writes namespace dataset to out folder, media files being hard linked instead of copied if link
+func Export(namespace, out string, link bool) (skipped []string, err error) {
+	sessions, err := recordings.Sessions(namespace)
+	if err != nil {
+		return
+	}
+	e := &exporter{out: out, link: link, labels: make(map[string]string)}
+	if err = os.MkdirAll(out, 0775); err != nil {
+		return
+	}
+
+	// participations per user, sessions without manifest are skipped
+	byUser := make(map[string][]participation)
+	for _, s := range sessions {
+		if s.Manifest == nil {
+			skipped = append(skipped, s.Id)
+			continue
+		}
+		for _, u := range s.Manifest.Users {
+			byUser[u.UserId] = append(byUser[u.UserId], participation{s, u})
+		}
+	}
+	userIds := []string{}
+	for userId := range byUser {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	participants := [][]string{}
+	for _, userId := range userIds {
+		ps := byUser[userId]
+		sort.SliceStable(ps, func(i, j int) bool {
+			return ps[i].session.Manifest.StartedAt.Before(ps[j].session.Manifest.StartedAt)
+		})
+		subject := e.subjectLabel(userId)
+		participants = append(participants, []string{"sub-" + subject, userId, strconv.Itoa(len(ps))})
+
+		rows := [][]string{}
+		for i, p := range ps {
+			if err = e.exportParticipation(p, subject, i+1); err != nil {
+				return
+			}
+			m := p.session.Manifest
+			rows = append(rows, []string{
+				fmt.Sprintf("ses-%02d", i+1),
+				formatTime(m.StartedAt),
+				m.RoomId,
+				p.session.Id,
+				strconv.Itoa(m.Duration),
+				strconv.Itoa(len(p.user.Connections)),
+			})
+		}
+		path := filepath.Join(out, "sub-"+subject, "sub-"+subject+"_sessions.tsv")
+		if err = writeTSV(path, []string{"session_id", "acq_time", "room_id", "ducksoup_session", "duration", "connections"}, rows); err != nil {
+			return
+		}
+	}
+	if err = e.exportComposites(sessions); err != nil {
+		return
+	}
+	if err = writeTSV(filepath.Join(out, "participants.tsv"), []string{"participant_id", "user_id", "sessions"}, participants); err != nil {
+		return
+	}
+	err = writeJSON(filepath.Join(out, "dataset_description.json"), map[string]interface{}{
+		"Name":        namespace,
+		"BIDSVersion": "1.7.0",
+		"DatasetType": "raw",
+		"GeneratedBy": []map[string]string{{"Name": "DuckSoup"}},
+		"ExportedAt":  time.Now(),
+	})
+	return
+}
+
+// Run is the export command
+func Run(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("out", "", "output folder (defaults to export-<namespace>)")
+	link := fs.Bool("link", false, "hard link media files instead of copying them (output must be on the same file system)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup export [options] <namespace>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing namespace")
+	}
+	namespace := fs.Arg(0)
+	if !recordings.ValidId(namespace) {
+		return fmt.Errorf("invalid namespace: %v", namespace)
+	}
+	if len(*out) == 0 {
+		*out = "export-" + namespace
+	}
+	skipped, err := Export(namespace, *out, *link)
+	for _, id := range skipped {
+		fmt.Fprintf(os.Stderr, "session without manifest skipped: %v\n", id)
+	}
+	return err
+}
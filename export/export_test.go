@@ -0,0 +1,64 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	manifest := `{
+		"roomId": "room-1",
+		"startedAt": "2022-03-01T10:00:00Z",
+		"users": [{"userId": "user-a", "connections": [{
+			"filePrefix": "20220301-100000.000-n-ns-r-room-1-u-user-a-c-1",
+			"recordingStartedAt": "2022-03-01T10:00:00Z",
+			"join": {"videoFormat": "H264", "width": 800},
+			"tracks": [{"kind": "audio", "codec": "audio/opus", "clockRate": 48000}],
+			"fxEvents": [{"time": "2022-03-01T10:00:02.5Z", "name": "pitch", "property": "pitch", "value": "1.2", "duration": 500}]
+		}]}],
+		"files": [{"path": "ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv", "sha256": "abc"}, {"path": "ns/20220301-100000.000-n-ns-r-room-1-room.mkv"}]
+	}`
+	for name, content := range map[string]string{
+		"data/ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv": "dry",
+		"data/ns/20220301-100000.000-n-ns-r-room-1-room.mkv":             "room",
+		"data/ns/20220301-100000.000-n-ns-r-room-1-manifest.json":        manifest,
+		"data/ns/20220301-110000.000-n-ns-r-room-2-u-user-b-c-1-dry.mkv": "unmanaged",
+	} {
+		os.MkdirAll(filepath.Dir(name), 0775)
+		if err := os.WriteFile(name, []byte(content), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	skipped, err := Export("ns", "out", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 {
+		t.Errorf("unmanaged session should be skipped: %v", skipped)
+	}
+
+	ses := "out/sub-usera/ses-01/sub-usera_ses-01"
+	if data, err := os.ReadFile(ses + "_rec-dry_run-1_av.mkv"); err != nil || string(data) != "dry" {
+		t.Errorf("recording not exported: %v", err)
+	}
+	if data, err := os.ReadFile(ses + "_rec-dry_run-1_av.json"); err != nil || !strings.Contains(string(data), `"SHA256": "abc"`) {
+		t.Errorf("unexpected sidecar: %s", data)
+	}
+	events, err := os.ReadFile(ses + "_run-1_events.tsv")
+	if err != nil || !strings.Contains(string(events), "2.500\t0.500\tfx_change\tpitch\tpitch\t1.2") {
+		t.Errorf("unexpected events: %s", events)
+	}
+	if _, err := os.Stat("out/derivatives/room/20220301-100000.000-n-ns-r-room-1_room.mkv"); err != nil {
+		t.Error("room recording not exported")
+	}
+	if participants, _ := os.ReadFile("out/participants.tsv"); !strings.Contains(string(participants), "sub-usera\tuser-a\t1") {
+		t.Errorf("unexpected participants: %s", participants)
+	}
+}
@@ -60,15 +60,16 @@ const clean = (obj) => {
 
 const parseJoinPayload = (peerOptions) => {
     // explicit list, without origin
-    let { roomId, userId, duration, size, width, height, audioFx, videoFx, frameRate, namespace, videoFormat, recordingMode, gpu } = peerOptions;
+    let { roomId, userId, duration, size, width, height, audioFx, videoFx, frameRate, namespace, videoFormat, recordingMode, gpu, roomRecording } = peerOptions;
     if (!["VP8", "H264"].includes(videoFormat)) videoFormat = null;
     if (isNaN(size)) size = null;
     if (isNaN(width)) width = null;
     if (isNaN(height)) height = null;
     if (isNaN(frameRate)) frameRate = null;
     if (!gpu) gpu = null;
+    if (!roomRecording) roomRecording = null;
 
-    return clean({ roomId, userId, duration, size, width, height, audioFx, videoFx, frameRate, namespace, videoFormat, recordingMode, gpu });
+    return clean({ roomId, userId, duration, size, width, height, audioFx, videoFx, frameRate, namespace, videoFormat, recordingMode, gpu, roomRecording });
 };
 
 const preferMono = (sdp) => {
@@ -369,8 +370,15 @@ class DuckSoup {
                 this._sendEvent({ kind: "start" }, true); // force with true since player is not already running
             } else if (message.kind === "ending") {
                 this._sendEvent({ kind: "ending" });
+            } else if (message.kind === "server_shutdown") {
+                this._sendEvent({ kind: "server_shutdown" });
+            } else if (message.kind === "pipeline_stalled" || message.kind === "pipeline_recovered" || message.kind === "gstreamer_message") {
+                this._sendEvent(message);
             } else if (message.kind === "files") {
                 this._sendEvent(message);
+            } else if (message.kind === "error-pipeline") {
+                // processing has failed: the connection goes on but other peers do not receive this peer anymore
+                this._sendEvent(message);
             } else if (message.kind.startsWith("error")) {
                 this._sendEvent(message);
                 this.stop(4000);
@@ -296,8 +296,16 @@ const ducksoupListener = (options) => (message) => {
         } else {
             replaceMessage("Connection terminated");
         }
+    } else if (kind === "server_shutdown") {
+        if (state.ducksoup) state.ducksoup.log("server_shutdown_received");
+    } else if (kind === "pipeline_stalled" || kind === "pipeline_recovered") {
+        if (state.ducksoup) state.ducksoup.log(`${kind}_received`, { kind: payload });
     } else if (kind === "error-duplicate") {
         replaceMessage("Connection denied (already connected)");
+    } else if (kind === "error-shutdown") {
+        replaceMessage("Connection denied (server is stopping)");
+    } else if (kind === "error-pipeline") {
+        if (state.ducksoup) state.ducksoup.log("error_pipeline_received", payload);
     } else if (kind === "error") {
         replaceMessage("Error");
     } else if (kind === "stats") {
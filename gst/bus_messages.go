@@ -0,0 +1,107 @@
+package gst
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/helpers"
+)
+
+const messagesBuffer = 64
+
+// separators used by gst.c to serialize message fields
+const (
+	fieldSep  = "\x1f"
+	recordSep = "\x1e"
+)
+
+// Message is a GStreamer bus message forwarded to Go: element (posted by plugins, for instance
+// analysis results), qos, latency or warning. See busMessages in config/gst.yml
+type Message struct {
+	Kind     string                 `json:"kind"`
+	Pipeline string                 `json:"pipeline"`
+	Source   string                 `json:"source"`         // name of the element that posted the message
+	Name     string                 `json:"name,omitempty"` // structure name, for element and qos messages
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// numbers and booleans are typed, other values (enums, fractions, caps...) are kept as serialized by GStreamer
+func parseValue(v string) interface{} {
+	if v == "true" || v == "false" {
+		return v == "true"
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return u
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	return v
+}
+
+func parseFields(serialized string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, record := range strings.Split(serialized, recordSep) {
+		kv := strings.SplitN(record, fieldSep, 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = parseValue(kv[1])
+		}
+	}
+	return fields
+}
+
+// relayed messages are read by the pipeline owner, the channel is closed when the pipeline is deleted.
+// Messages are dropped if not read fast enough
+func (p *Pipeline) Messages() <-chan Message {
+	return p.messagesCh
+}
+
+func (p *Pipeline) busMessage(m Message) {
+	logged := helpers.Contains(config.BusMessages.Log, m.Kind)
+	relayed := helpers.Contains(config.BusMessages.Relay, m.Kind)
+	if !logged && !relayed {
+		return
+	}
+	if host != nil {
+		data, _ := json.Marshal(m)
+		host.send(msgBusMessage, data)
+		return
+	}
+
+	if logged {
+		var e = p.logger.Debug()
+		switch m.Kind {
+		case "element":
+			e = p.logger.Info()
+		case "warning":
+			e = p.logger.Warn()
+		}
+		e.Str("source", m.Source).Str("name", m.Name).Interface("fields", m.Fields).Msg("gstreamer_" + m.Kind)
+	}
+	if relayed {
+		p.mu.Lock()
+		if !p.eventsClosed {
+			select {
+			case p.messagesCh <- m:
+			default:
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// messages forwarded by a worker process, numbers are kept as is
+func decodeMessage(payload []byte) (m Message, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	err = decoder.Decode(&m)
+	return
+}
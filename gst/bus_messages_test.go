@@ -0,0 +1,16 @@
+package gst
+
+import "testing"
+
+func TestParseFields(t *testing.T) {
+	fields := parseFields("faces\x1f2\x1econfidence\x1f0.75\x1etracked\x1ftrue\x1erunning-time\x1f18446744073709551615\x1eformat\x1fbuffers\x1e")
+	if fields["faces"] != int64(2) || fields["confidence"] != 0.75 || fields["tracked"] != true {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if fields["running-time"] != uint64(18446744073709551615) || fields["format"] != "buffers" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if len(parseFields("")) != 0 {
+		t.Error("no fields expected")
+	}
+}
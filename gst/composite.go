@@ -0,0 +1,156 @@
+package gst
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/types"
+)
+
+type compositeInput struct {
+	Index  int
+	File   string
+	X      int
+	Y      int
+	Offset int64 // in ns, relative to first pipeline
+}
+
+// even dimensions are needed by I420 encoders
+func even(v int) int {
+	return v - v%2
+}
+
+// SetMediaStart records the capture time of the first packet of a track pushed to the pipeline, as
+// given by the sender report NTP/RTP mapping (the earliest of audio and video is kept)
+func (p *Pipeline) SetMediaStart(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mediaStartedAt.IsZero() || t.Before(p.mediaStartedAt) {
+		p.mediaStartedAt = t
+	}
+}
+
+// falls back to the pipeline start time if no media start has been set
+func (p *Pipeline) mediaStart() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mediaStartedAt.IsZero() {
+		return p.startedAt
+	}
+	return p.mediaStartedAt
+}
+
+// main recording of a pipeline: wet if any, dry otherwise
+func (p *Pipeline) mainOutputFile() string {
+	files := p.outputFiles()
+	return files[len(files)-1]
+}
+
+// CreateCompositePipeline creates a GStreamer pipeline that composites (grid layout) and mixes
+// the recordings of the given (deleted) pipelines. Recordings are aligned on the capture time of their
+// first packet (see SetMediaStart), which relies on the clocks of the senders being synchronized;
+// pipelines without sender report are aligned on their start time instead. The room level join
+// defines namespace, room, video format and cell size. Only muxed (or concatenated segmented) recordings
+// are supported and the returned pipeline is nil if there is nothing to composite.
+func CreateCompositePipeline(join types.JoinPayload, filePrefix string, pipelines []*Pipeline, logWriter io.Writer) *Pipeline {
+	var started []*Pipeline
+	for _, p := range pipelines {
+		if !p.IsStarted() {
+			continue
+		}
+		if p.join.RecordingMode == "muxed" {
+			started = append(started, p)
+		} else if p.IsSegmented() {
+			// if segments have been concatenated
+			if _, err := os.Stat("data/" + p.mainOutputFile()); err == nil {
+				started = append(started, p)
+			}
+		}
+	}
+	if len(started) == 0 {
+		return nil
+	}
+	starts := make(map[*Pipeline]time.Time)
+	for _, p := range started {
+		starts[p] = p.mediaStart()
+	}
+	sort.SliceStable(started, func(i, j int) bool {
+		return starts[started[i]].Before(starts[started[j]])
+	})
+
+	// one grid cell per user (reconnections of a given user share the same cell)
+	var userIds []string
+	for _, p := range started {
+		if !helpers.Contains(userIds, p.join.UserId) {
+			userIds = append(userIds, p.join.UserId)
+		}
+	}
+	sort.Strings(userIds)
+	columns := int(math.Ceil(math.Sqrt(float64(len(userIds)))))
+	rows := int(math.Ceil(float64(len(userIds)) / float64(columns)))
+	cellWidth, cellHeight := join.Width, join.Height
+	if columns > 2 {
+		// keep output width under twice the input width
+		cellWidth, cellHeight = cellWidth*2/columns, cellHeight*2/columns
+	}
+	cellWidth, cellHeight = even(cellWidth), even(cellHeight)
+
+	var inputs []compositeInput
+	for i, p := range started {
+		cell := sort.SearchStrings(userIds, p.join.UserId)
+		inputs = append(inputs, compositeInput{
+			Index:  i,
+			File:   p.mainOutputFile(),
+			X:      (cell % columns) * cellWidth,
+			Y:      (cell / columns) * cellHeight,
+			Offset: starts[p].Sub(starts[started[0]]).Nanoseconds(),
+		})
+	}
+
+	audioCodec := config.Opus
+	videoCodec := newVideoCodec(join)
+	data := struct {
+		Video      codec
+		Audio      codec
+		Namespace  string
+		FilePrefix string
+		Width      int
+		Height     int
+		CellWidth  int
+		CellHeight int
+		Inputs     []compositeInput
+	}{
+		videoCodec,
+		audioCodec,
+		join.Namespace,
+		filePrefix,
+		columns * cellWidth,
+		rows * cellHeight,
+		cellWidth,
+		cellHeight,
+		inputs,
+	}
+
+	var buf bytes.Buffer
+	if err := roomCompositeTemplater.Execute(&buf, data); err != nil {
+		panic(err)
+	}
+
+	// room level pipeline, not related to the user who created the room
+	join.UserId = ""
+	p := newPipeline(join, filePrefix, formatPipelineDef(buf), logWriter)
+	p.start()
+	return p
+}
+
+// CompositeFile is the room level recording produced by CreateCompositePipeline
+func CompositeFile(namespace, filePrefix string) string {
+	return fileName(namespace, filePrefix, "room")
+}
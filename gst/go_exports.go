@@ -6,13 +6,12 @@ package gst
 */
 import "C"
 import (
-	"errors"
 	"regexp"
 	"strconv"
+	"time"
 	"unsafe"
 
 	"github.com/creamlab/ducksoup/helpers"
-	"github.com/creamlab/ducksoup/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -40,6 +39,11 @@ func init() {
 //export goDeletePipeline
 func goDeletePipeline(cId *C.char) {
 	id := C.GoString(cId)
+	if host != nil {
+		p, _ := pipelineStoreSingleton.find(id)
+		host.deleted(p)
+		return
+	}
 	pipelineStoreSingleton.delete(id)
 }
 
@@ -48,23 +52,10 @@ func writeNewSample(kind string, cId *C.char, buffer unsafe.Pointer, bufferLen C
 	p, ok := pipelineStoreSingleton.find(id)
 
 	if ok {
-		var output types.TrackWriter
-		if kind == "audio" {
-			output = p.audioOutput
-		} else {
-			output = p.videoOutput
-		}
-
-		buf := C.GoBytes(buffer, bufferLen)
-		if err := output.Write(buf); err != nil {
-			// TODO err contains the ID of the failing PeerConnections
-			// we may store a callback on the Pipeline struct (the callback would remove those peers and update signaling)
-			p.logger.Error().Err(err).Msg("track_write_failed")
-		}
+		p.writeSample(kind, C.GoBytes(buffer, bufferLen))
 	} else {
-		// discards buffer
-		// TODO return error to gst.c and stop processing?
-		p.logger.Error().Msg("pipeline_not_found")
+		// discards buffer (pipeline has been deleted meanwhile)
+		log.Error().Str("context", "pipeline").Str("pipeline", id).Str("kind", kind).Msg("pipeline_not_found")
 	}
 	C.free(buffer)
 }
@@ -83,15 +74,31 @@ func goWriteVideo(cId *C.char, buffer unsafe.Pointer, bufferLen C.int, pts C.int
 func goPipelineLog(cId *C.char, msg *C.char, isError C.int) {
 	id := C.GoString(cId)
 	m := C.GoString(msg)
+	if host != nil {
+		host.log(m, isError == 1)
+		return
+	}
 	p, ok := pipelineStoreSingleton.find(id)
 
 	if ok {
-		if isError == 0 {
-			p.logger.Error().Err(errors.New(m)).Msg("gstreamer_pipeline_error")
-		} else {
-			// CAUTION: not documented
-			p.logger.Log().Err(errors.New(m)).Msg("gstreamer_pipeline_log")
-		}
+		p.log(m, isError == 1)
+	}
+}
+
+//export goPipelineMessage
+func goPipelineMessage(cId, cKind, cSource, cName, cFields *C.char) {
+	id := C.GoString(cId)
+	p, ok := pipelineStoreSingleton.find(id)
+
+	if ok {
+		p.busMessage(Message{
+			Kind:     C.GoString(cKind),
+			Pipeline: id,
+			Source:   C.GoString(cSource),
+			Name:     C.GoString(cName),
+			Fields:   parseFields(C.GoString(cFields)),
+			Time:     time.Now(),
+		})
 	}
 }
 
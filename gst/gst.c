@@ -5,6 +5,10 @@
 #include "gst.h"
 
 #define GST_RTP_EVENT_RETRANSMISSION_REQUEST "GstRTPRetransmissionRequest"
+#define GST_FORCE_KEY_UNIT_EVENT "GstForceKeyUnit"
+// separators of message fields sent to Go (see goPipelineMessage)
+#define FIELD_SEP "\x1f"
+#define RECORD_SEP "\x1e"
 
 // Internals (snake_case)
 
@@ -33,6 +37,31 @@ void stop_pipeline(GstElement* pipeline) {
 }
 
 
+static gboolean serialize_field(GQuark field_id, const GValue *value, gpointer data)
+{
+    GString *fields = (GString*) data;
+    gchar *str = G_VALUE_HOLDS_STRING(value) ? g_value_dup_string(value) : gst_value_serialize(value);
+
+    if (str != NULL) {
+        g_string_append_printf(fields, "%s" FIELD_SEP "%s" RECORD_SEP, g_quark_to_string(field_id), str);
+        g_free(str);
+    }
+    return TRUE;
+}
+
+// structure fields (if any) are appended to fields, which is freed
+static void forward_message(char *id, GstMessage *msg, const gchar *kind, const GstStructure *structure, GString *fields)
+{
+    const gchar *name = "";
+
+    if (structure != NULL) {
+        name = gst_structure_get_name(structure);
+        gst_structure_foreach(structure, serialize_field, fields);
+    }
+    goPipelineMessage(id, (char*)kind, (char*)GST_MESSAGE_SRC_NAME(msg), (char*)name, fields->str);
+    g_string_free(fields, TRUE);
+}
+
 static gboolean bus_callback(GstBus *bus, GstMessage *msg, gpointer data)
 {
     GstElement* pipeline = (GstElement*) data;
@@ -46,17 +75,66 @@ static gboolean bus_callback(GstBus *bus, GstMessage *msg, gpointer data)
     }
     case GST_MESSAGE_ERROR:
     {
-        GError *error;
+        GError *error = NULL;
+        gchar *debug = NULL;
 
-        char msgBuf[100];
-        sprintf(msgBuf, "ERR [gst.c] from element %d: %s\n",GST_OBJECT_NAME (msg->src), error->message);
-        goPipelineLog(id, msgBuf, 1);
+        gst_message_parse_error(msg, &error, &debug);
+        gchar *msgStr = g_strdup_printf("from element %s: %s", GST_OBJECT_NAME(msg->src), error->message);
+        goPipelineLog(id, msgStr, 1);
 
+        g_free(msgStr);
+        g_free(debug);
         g_error_free(error);
 
         stop_pipeline(pipeline);
         break;
     }
+    case GST_MESSAGE_ELEMENT:
+    {
+        forward_message(id, msg, "element", gst_message_get_structure(msg), g_string_new(NULL));
+        break;
+    }
+    case GST_MESSAGE_QOS:
+    {
+        forward_message(id, msg, "qos", gst_message_get_structure(msg), g_string_new(NULL));
+        break;
+    }
+    case GST_MESSAGE_LATENCY:
+    {
+        // reports the latency currently computed for the pipeline
+        GString *fields = g_string_new(NULL);
+        GstQuery *query = gst_query_new_latency();
+        if (gst_element_query(pipeline, query)) {
+            gboolean live;
+            GstClockTime min, max;
+            gst_query_parse_latency(query, &live, &min, &max);
+            g_string_append_printf(fields, "live" FIELD_SEP "%s" RECORD_SEP, live ? "true" : "false");
+            g_string_append_printf(fields, "min-latency" FIELD_SEP "%" G_GUINT64_FORMAT RECORD_SEP, min);
+            if (GST_CLOCK_TIME_IS_VALID(max)) {
+                g_string_append_printf(fields, "max-latency" FIELD_SEP "%" G_GUINT64_FORMAT RECORD_SEP, max);
+            }
+        }
+        gst_query_unref(query);
+        forward_message(id, msg, "latency", NULL, fields);
+        break;
+    }
+    case GST_MESSAGE_WARNING:
+    {
+        GError *error = NULL;
+        gchar *debug = NULL;
+        GString *fields = g_string_new(NULL);
+
+        gst_message_parse_warning(msg, &error, &debug);
+        g_string_append_printf(fields, "message" FIELD_SEP "%s" RECORD_SEP, error->message);
+        if (debug != NULL) {
+            g_string_append_printf(fields, "debug" FIELD_SEP "%s" RECORD_SEP, debug);
+        }
+        forward_message(id, msg, "warning", NULL, fields);
+
+        g_free(debug);
+        g_error_free(error);
+        break;
+    }
     default:
         //g_print("got message %s\n", gst_message_type_get_name (GST_MESSAGE_TYPE (msg)));
         break;
@@ -147,6 +225,13 @@ void gstStartMainLoop(void)
     g_main_loop_run(gstreamer_main_loop);
 }
 
+void gstStopMainLoop(void)
+{
+    if (gstreamer_main_loop) {
+        g_main_loop_quit(gstreamer_main_loop);
+    }
+}
+
 GstElement *gstParsePipeline(char *pipelineStr, char *id)
 {    
     gst_init(NULL, NULL);
@@ -167,36 +252,19 @@ void gstStartPipeline(GstElement *pipeline)
     GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
     gst_bus_add_watch(bus, bus_callback, pipeline);
     gst_object_unref(bus);
-    // src
-    GstElement *video_src = gst_bin_get_by_name(GST_BIN(pipeline), "video_src");
-    GstPad *video_src_pad = gst_element_get_static_pad(video_src, "src");
-    gst_object_unref(video_src);
-    gst_object_unref(video_src_pad);
-    // sinks
+    // sinks (missing in file based pipelines, for instance when compositing room recordings)
     GstElement *audio_sink = gst_bin_get_by_name(GST_BIN(pipeline), "audio_sink");
     GstElement *video_sink = gst_bin_get_by_name(GST_BIN(pipeline), "video_sink");
-    g_object_set(audio_sink, "emit-signals", TRUE, NULL);
-    g_signal_connect(audio_sink, "new-sample", G_CALLBACK(new_audio_sample_callback), pipeline);
-    gst_object_unref(audio_sink);
-    g_object_set(video_sink, "emit-signals", TRUE, NULL);
-    g_signal_connect(video_sink, "new-sample", G_CALLBACK(new_video_sample_callback), pipeline);
-    gst_object_unref(video_sink);
-    // buffer request pad
-    GstElement *audio_buffer = gst_bin_get_by_name(GST_BIN(pipeline), "audio_buffer");
-    GstElement *video_buffer = gst_bin_get_by_name(GST_BIN(pipeline), "video_buffer");
-
-    // TODO push_rtcp does not work
-    // TODO deprecated gst_element_get_request_pad https://gitlab.freedesktop.org/gstreamer/gst-docs/-/merge_requests/152
-    // update when GStreamer 1.20 is out
-    // GstPad *audio_rtcp_pad = gst_element_get_request_pad (audio_buffer, "sink_rtcp");
-    // GstPad *video_rtcp_pad = gst_element_get_request_pad (video_buffer, "sink_rtcp");
-    // gst_pad_activate_mode (audio_rtcp_pad, GST_PAD_MODE_PULL, TRUE);
-    // gst_pad_activate_mode (video_rtcp_pad, GST_PAD_MODE_PULL, TRUE);
-    // gst_object_unref(audio_buffer);
-    // gst_object_unref(video_buffer);
-    // gst_object_unref(audio_rtcp_pad);
-    // gst_object_unref(video_rtcp_pad);
-
+    if (audio_sink) {
+        g_object_set(audio_sink, "emit-signals", TRUE, NULL);
+        g_signal_connect(audio_sink, "new-sample", G_CALLBACK(new_audio_sample_callback), pipeline);
+        gst_object_unref(audio_sink);
+    }
+    if (video_sink) {
+        g_object_set(video_sink, "emit-signals", TRUE, NULL);
+        g_signal_connect(video_sink, "new-sample", G_CALLBACK(new_video_sample_callback), pipeline);
+        gst_object_unref(video_sink);
+    }
 
     gst_element_set_state(pipeline, GST_STATE_PLAYING);
 }
@@ -231,6 +299,25 @@ void gstPushBuffer(char *srcname, GstElement *pipeline, void *buffer, int len)
     }
 }
 
+void gstRequestKeyFrame(GstElement *pipeline, char *name)
+{
+    GstElement* el;
+
+    el = gst_bin_get_by_name(GST_BIN(pipeline), name);
+
+    if(el) {
+        // same structure as gst_video_event_new_upstream_force_key_unit (avoids linking gstreamer-video)
+        GstStructure *s = gst_structure_new(GST_FORCE_KEY_UNIT_EVENT,
+            "running-time", G_TYPE_UINT64, GST_CLOCK_TIME_NONE,
+            "all-headers", G_TYPE_BOOLEAN, TRUE,
+            "count", G_TYPE_UINT, 0,
+            NULL);
+        // upstream event sent to the encoder src pad
+        gst_element_send_event(el, gst_event_new_custom(GST_EVENT_CUSTOM_UPSTREAM, s));
+        gst_object_unref(el);
+    }
+}
+
 // float get/set
 
 float gstGetPropFloat(GstElement *pipeline, char *name, char *prop) {
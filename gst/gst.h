@@ -10,13 +10,16 @@ extern void goWriteAudio(char *id, void *buffer, int bufferLen, int pts);
 extern void goWriteVideo(char *id, void *buffer, int bufferLen, int pts);
 extern void goDeletePipeline(char *id);
 extern void goPipelineLog(char *id, char *msg, int isError);
+extern void goPipelineMessage(char *id, char *kind, char *source, char *name, char *fields);
 extern void goDebugLog(int level, char *file, char *function,int line, char *msg);
 
 void gstStartMainLoop(void);
+void gstStopMainLoop(void);
 GstElement *gstParsePipeline(char *pipelineStr, char *id);
 void gstStartPipeline(GstElement *pipeline);
 void gstStopPipeline(GstElement *pipeline);
 void gstPushBuffer(char *src, GstElement *pipeline, void *buffer, int len);
+void gstRequestKeyFrame(GstElement *pipeline, char *name);
 
 // get/set props
 float gstGetPropFloat(GstElement *pipeline, char *elName, char *elProp);
@@ -28,6 +31,4 @@ void gstSetPropInt(GstElement *pipeline, char *elName, char *elProp, gint elValu
 guint64 gstGetPropUint64(GstElement *pipeline, char *name, char *prop);
 void gstSetPropUint64(GstElement *pipeline, char *name, char *prop, guint64 value);
 
-// void gstPushRTCPBuffer(char *name, GstElement *pipeline, void *buffer, int len);
-
 #endif
\ No newline at end of file
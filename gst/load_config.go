@@ -21,6 +21,24 @@ type gstreamerConfig struct {
 	VP8                        codec `yaml:"vp8"`
 	X264                       codec
 	NV264                      codec `yaml:"nv264"`
+	Segments                   segmentsConfig
+	Watchdog                   watchdogConfig
+	BusMessages                busMessagesConfig `yaml:"busMessages"`
+}
+
+type segmentsConfig struct {
+	Duration int // seconds
+	Keep     bool
+}
+
+type watchdogConfig struct {
+	Interval     int // milliseconds
+	StallTimeout int `yaml:"stallTimeout"` // milliseconds
+}
+
+type busMessagesConfig struct {
+	Log   []string // kinds of messages
+	Relay []string
 }
 
 type codec struct {
@@ -51,7 +69,7 @@ func (c codec) RawCapsWith(width, height, frameRate int) (output string) {
 	return
 }
 
-var muxedRecordingTemplater, splitRecordingTemplater, passthroughTemplater, noRecordingTemplater *template.Template
+var muxedRecordingTemplater, segmentedRecordingTemplater, splitRecordingTemplater, passthroughTemplater, noRecordingTemplater, roomCompositeTemplater, segmentsConcatTemplater *template.Template
 var config gstreamerConfig
 
 func init() {
@@ -84,6 +102,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	segmentedRecordingTemplater, err = template.New("segmentedRecording").Parse(helpers.ReadFile("config/pipelines/segmented_recording.gtpl"))
+	if err != nil {
+		panic(err)
+	}
 	splitRecordingTemplater, err = template.New("splitRecording").Parse(helpers.ReadFile("config/pipelines/split_recording.gtpl"))
 	if err != nil {
 		panic(err)
@@ -96,6 +118,14 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	roomCompositeTemplater, err = template.New("roomComposite").Parse(helpers.ReadFile("config/pipelines/room_composite.gtpl"))
+	if err != nil {
+		panic(err)
+	}
+	segmentsConcatTemplater, err = template.New("segmentsConcat").Parse(helpers.ReadFile("config/pipelines/segments_concat.gtpl"))
+	if err != nil {
+		panic(err)
+	}
 
 	// log
 	log.Info().Str("context", "init").Str("config", fmt.Sprintf("%+v", config)).Msg("gstreamer_config_loaded")
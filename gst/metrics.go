@@ -0,0 +1,25 @@
+package gst
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pipelineErrorCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ducksoup_pipeline_errors_total",
+		Help: "Errors reported by GStreamer pipelines",
+	})
+	pipelineStallCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ducksoup_pipeline_stalls_total",
+		Help: "Stalls detected by the pipeline watchdog",
+	}, []string{"kind"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ducksoup_pipelines_active",
+		Help: "GStreamer pipelines currently running",
+	}, func() float64 {
+		pipelineStoreSingleton.Lock()
+		defer pipelineStoreSingleton.Unlock()
+		return float64(len(pipelineStoreSingleton.index))
+	})
+)
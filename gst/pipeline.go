@@ -7,12 +7,16 @@ package gst
 */
 import "C"
 import (
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/creamlab/ducksoup/events"
 	"github.com/creamlab/ducksoup/helpers"
 	"github.com/creamlab/ducksoup/types"
 	"github.com/google/uuid"
@@ -34,14 +38,29 @@ type Pipeline struct {
 	mu          sync.Mutex
 	id          string // same as local/output track id
 	join        types.JoinPayload
-	cPipeline   *C.GstElement
+	cPipeline   *C.GstElement // nil if the pipeline runs in a worker process
+	worker      *worker
 	audioOutput types.TrackWriter
 	videoOutput types.TrackWriter
 	filePrefix  string
+	startedAt   time.Time
+	// capture time of the first packet pushed, used to align room composites
+	mediaStartedAt time.Time
 	// stoppedCount=2 if audio and video have been stopped
 	stoppedCount int
+	// closed when GStreamer pipeline is deleted (after EOS or error), meaning recordings are finalized
+	doneCh chan struct{}
+	errors []types.ManifestError
+	// errors and unexpected EOS, closed when pipeline is deleted
+	eventsCh     chan Event
+	messagesCh   chan Message
+	eventsClosed bool
+	// watchdog, per kind
+	healthMu sync.Mutex
+	health   map[string]*trackHealth
 	// log
-	logger zerolog.Logger
+	logger    zerolog.Logger
+	logWriter io.Writer
 }
 
 func fileName(namespace string, prefix string, suffix string) string {
@@ -50,57 +69,102 @@ func fileName(namespace string, prefix string, suffix string) string {
 
 // API
 
+// StartMainLoop blocks until StopMainLoop is called
 func StartMainLoop() {
 	C.gstStartMainLoop()
 }
 
-// create a GStreamer pipeline
-func CreatePipeline(join types.JoinPayload, filePrefix string) *Pipeline {
-
-	pipelineStr := newPipelineDef(join, filePrefix)
-	id := uuid.New().String()
+func StopMainLoop() {
+	C.gstStopMainLoop()
+}
 
-	cPipelineStr := C.CString(pipelineStr)
-	cId := C.CString(id)
-	defer C.free(unsafe.Pointer(cPipelineStr))
-	defer C.free(unsafe.Pointer(cId))
+// create a GStreamer pipeline, logging to logWriter
+func CreatePipeline(join types.JoinPayload, filePrefix string, logWriter io.Writer) *Pipeline {
+	return newPipeline(join, filePrefix, newPipelineDef(join, filePrefix), logWriter)
+}
 
-	logger := log.With().
-		Str("context", "pipeline").
-		Str("namespace", join.Namespace).
-		Str("room", join.RoomId).
-		Str("user", join.UserId).
-		Str("pipeline", id).
-		Logger()
+func newPipeline(join types.JoinPayload, filePrefix, pipelineStr string, logWriter io.Writer) *Pipeline {
+	id := uuid.New().String()
 
 	p := &Pipeline{
 		mu:           sync.Mutex{},
 		id:           id,
 		join:         join,
-		cPipeline:    C.gstParsePipeline(cPipelineStr, cId),
 		filePrefix:   filePrefix,
 		stoppedCount: 0,
-		logger:       logger,
+		doneCh:       make(chan struct{}),
+		eventsCh:     make(chan Event, eventsBuffer),
+		messagesCh:   make(chan Message, messagesBuffer),
+		health:       newHealthIndex(),
+		logger:       pipelineLogger(join, id, logWriter),
+		logWriter:    logWriter,
 	}
 
-	p.logger.Info().Str("pipeline", pipelineStr).Msg("pipeline_created")
+	if workersEnabled {
+		w, err := startWorker(p, pipelineStr)
+		if err != nil {
+			p.logger.Error().Err(err).Msg("pipeline_worker_start_failed")
+		} else {
+			p.worker = w
+		}
+	}
+	if p.worker == nil {
+		p.cPipeline = parsePipeline(id, pipelineStr)
+	}
+
+	p.logger.Info().Str("pipeline", pipelineStr).Bool("worker", p.worker != nil).Msg("pipeline_created")
 
 	pipelineStoreSingleton.add(p)
 	return p
 }
 
-func (p *Pipeline) outputFiles() []string {
-	namespace := p.join.Namespace
+func parsePipeline(id, pipelineStr string) *C.GstElement {
+	cPipelineStr := C.CString(pipelineStr)
+	cId := C.CString(id)
+	defer C.free(unsafe.Pointer(cPipelineStr))
+	defer C.free(unsafe.Pointer(cId))
+
+	return C.gstParsePipeline(cPipelineStr, cId)
+}
+
+// user is omitted for room level pipelines (composite recordings)
+func pipelineLogger(join types.JoinPayload, id string, logWriter io.Writer) zerolog.Logger {
+	c := log.Output(logWriter).With().
+		Str("context", "pipeline").
+		Str("namespace", join.Namespace).
+		Str("room", join.RoomId)
+	if len(join.UserId) > 0 {
+		c = c.Str("user", join.UserId)
+	}
+	return c.Str("pipeline", id).Logger()
+}
+
+func (p *Pipeline) outputSuffixes() []string {
 	hasFx := len(p.join.AudioFx) > 0 || len(p.join.VideoFx) > 0
 	if hasFx {
-		return []string{fileName(namespace, p.filePrefix, "dry"), fileName(namespace, p.filePrefix, "wet")}
+		return []string{"dry", "wet"}
 	} else {
-		return []string{fileName(namespace, p.filePrefix, "dry")}
+		return []string{"dry"}
 	}
 }
 
-func (p *Pipeline) PushRTP(kind string, buffer []byte) {
-	s := C.CString(kind + "_src")
+func (p *Pipeline) outputFiles() (files []string) {
+	for _, suffix := range p.outputSuffixes() {
+		files = append(files, fileName(p.join.Namespace, p.filePrefix, suffix))
+	}
+	return
+}
+
+// Writes returns true if path (relative to data folder) is a recording, or a segment, of this pipeline
+func (p *Pipeline) Writes(path string) bool {
+	return strings.HasPrefix(path, p.join.Namespace+"/"+p.filePrefix+"-")
+}
+
+func (p *Pipeline) push(src string, buffer []byte) {
+	if p.deleted() {
+		return
+	}
+	s := C.CString(src)
 	defer C.free(unsafe.Pointer(s))
 
 	b := C.CBytes(buffer)
@@ -108,13 +172,56 @@ func (p *Pipeline) PushRTP(kind string, buffer []byte) {
 	C.gstPushBuffer(s, p.cPipeline, b, C.int(len(buffer)))
 }
 
+func (p *Pipeline) PushRTP(kind string, buffer []byte) {
+	p.recordPush(kind)
+	if p.worker != nil {
+		p.worker.push(msgPushRTP, kind, buffer)
+		return
+	}
+	p.push(kind+"_src", buffer)
+}
+
+// RTCP is pushed to the jitter buffer sink_rtcp pad (discarded if there is no jitter buffer for this kind).
+// Without rtpbin, sender reports do not drive lip sync nor clock skew correction, see config/README.md
 func (p *Pipeline) PushRTCP(kind string, buffer []byte) {
-	s := C.CString(kind + "_buffer")
-	defer C.free(unsafe.Pointer(s))
+	if p.worker != nil {
+		p.worker.push(msgPushRTCP, kind, buffer)
+		return
+	}
+	p.push(kind+"_rtcp_src", buffer)
+}
 
-	b := C.CBytes(buffer)
-	defer C.free(b)
-	//C.gstPushRTCPBuffer(s, p.cPipeline, b, C.int(len(buffer)))
+// sample processed by the GStreamer pipeline, written to the output track
+func (p *Pipeline) writeSample(kind string, buf []byte) {
+	p.recordSample(kind)
+	var output types.TrackWriter
+	if kind == "audio" {
+		output = p.audioOutput
+	} else {
+		output = p.videoOutput
+	}
+	if err := output.Write(buf); err != nil {
+		// TODO err contains the ID of the failing PeerConnections
+		// we may store a callback on the Pipeline struct (the callback would remove those peers and update signaling)
+		p.logger.Error().Err(err).Msg("track_write_failed")
+	}
+}
+
+// message from the GStreamer bus (or about the worker process)
+func (p *Pipeline) log(msg string, isError bool) {
+	if isError {
+		p.addError(ErrorEvent, msg)
+		p.emit(ErrorEvent, msg)
+		pipelineErrorCounter.Inc()
+		events.Publish(events.PipelineError, p.join.Namespace, p.join.RoomId, p.join.UserId, map[string]interface{}{
+			"pipeline": p.id,
+			"error":    msg,
+		})
+		p.logger.Error().Err(errors.New(msg)).Msg("gstreamer_pipeline_error")
+	} else {
+		// CAUTION: not documented
+		p.logger.Log().Err(errors.New(msg)).Msg("gstreamer_pipeline_log")
+	}
 }
 
 func (p *Pipeline) BindTrack(kind string, t types.TrackWriter) (files []string) {
@@ -132,9 +239,80 @@ func (p *Pipeline) BindTrack(kind string, t types.TrackWriter) (files []string)
 
 // start the GStreamer pipeline
 func (p *Pipeline) start() {
-	C.gstStartPipeline(p.cPipeline)
+	p.mu.Lock()
+	p.startedAt = time.Now()
+	p.mu.Unlock()
+
+	p.startGst()
+	go p.watch()
 	recording_prefix := fmt.Sprintf("%s/%s", p.join.Namespace, p.filePrefix)
 	p.logger.Info().Str("recording_prefix", recording_prefix).Msg("pipeline_started")
+	events.Publish(events.PipelineStarted, p.join.Namespace, p.join.RoomId, p.join.UserId, map[string]interface{}{
+		"pipeline":        p.id,
+		"recordingPrefix": recording_prefix,
+	})
+}
+
+func (p *Pipeline) startGst() {
+	if p.worker != nil {
+		p.worker.send(msgStart, nil)
+		return
+	}
+	if !p.deleted() {
+		C.gstStartPipeline(p.cPipeline)
+	}
+}
+
+// sends EOS
+func (p *Pipeline) stopGst() {
+	if p.worker != nil {
+		p.worker.send(msgStop, nil)
+		return
+	}
+	if !p.deleted() {
+		C.gstStopPipeline(p.cPipeline)
+	}
+}
+
+func (p *Pipeline) IsStarted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return !p.startedAt.IsZero()
+}
+
+func (p *Pipeline) addError(kind EventKind, msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.errors = append(p.errors, types.ManifestError{
+		Time:     time.Now(),
+		UserId:   p.join.UserId,
+		Pipeline: p.id,
+		Kind:     string(kind),
+		Message:  msg,
+	})
+}
+
+// GStreamer errors that occured while running this pipeline
+func (p *Pipeline) Errors() []types.ManifestError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.errors
+}
+
+// wall-clock time matching the beginning of recordings
+func (p *Pipeline) StartedAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.startedAt
+}
+
+// Done is closed when the GStreamer pipeline has been deleted
+func (p *Pipeline) Done() <-chan struct{} {
+	return p.doneCh
 }
 
 // stop the GStreamer pipeline
@@ -144,11 +322,23 @@ func (p *Pipeline) Stop() {
 
 	p.stoppedCount += 1
 	if p.stoppedCount == 2 { // audio and video buffers from mixerSlice have been stopped
-		C.gstStopPipeline(p.cPipeline)
+		p.stopGst()
 		p.logger.Info().Msg("pipeline_stopped")
 	}
 }
 
+// stop the GStreamer pipeline even if audio or video buffers are still flowing
+func (p *Pipeline) forceStop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stoppedCount < 2 {
+		p.stoppedCount = 2
+		p.stopGst()
+		p.logger.Info().Msg("pipeline_force_stopped")
+	}
+}
+
 func (p *Pipeline) getPropInt(name string, prop string) int {
 	cName := C.CString(name)
 	cProp := C.CString(prop)
@@ -161,26 +351,69 @@ func (p *Pipeline) getPropInt(name string, prop string) int {
 
 func (p *Pipeline) setPropInt(name string, prop string, value int) {
 	// fx prefix needed (added during pipeline initialization)
+	p.setProp(name, prop, "int", strconv.Itoa(value))
+}
+
+func (p *Pipeline) setPropFloat(name string, prop string, value float32) {
+	// fx prefix needed (added during pipeline initialization)
+	p.setProp(name, prop, "float", strconv.FormatFloat(float64(value), 'f', -1, 32))
+}
+
+// kind is the GLib type of the property (float, double, int or uint64), value is parsed accordingly
+func (p *Pipeline) setProp(name string, prop string, kind string, value string) {
+	if p.worker != nil {
+		p.worker.setProp(name, prop, kind, value)
+		return
+	}
+	if p.deleted() {
+		return
+	}
+
 	cName := C.CString(name)
 	cProp := C.CString(prop)
-	cValue := C.int(value)
 
 	defer C.free(unsafe.Pointer(cName))
 	defer C.free(unsafe.Pointer(cProp))
 
-	C.gstSetPropInt(p.cPipeline, cName, cProp, cValue)
+	switch kind {
+	case "float":
+		if v, err := strconv.ParseFloat(value, 32); err == nil {
+			cValue := C.float(float32(v))
+			C.gstSetPropFloat(p.cPipeline, cName, cProp, cValue)
+		}
+	case "double":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			cValue := C.double(v)
+			C.gstSetPropDouble(p.cPipeline, cName, cProp, cValue)
+		}
+	case "int":
+		if v, err := strconv.ParseInt(value, 10, 32); err == nil {
+			cValue := C.int(int32(v))
+			C.gstSetPropInt(p.cPipeline, cName, cProp, cValue)
+		}
+	case "uint64":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			cValue := C.ulong(v)
+			C.gstSetPropUint64(p.cPipeline, cName, cProp, cValue)
+		}
+	}
 }
 
-func (p *Pipeline) setPropFloat(name string, prop string, value float32) {
-	// fx prefix needed (added during pipeline initialization)
+func (p *Pipeline) getPropFloat(name string, prop string) float32 {
+	if p.worker != nil {
+		return p.worker.getPropFloat(name, prop)
+	}
+	if p.deleted() {
+		return 0
+	}
+
 	cName := C.CString(name)
 	cProp := C.CString(prop)
-	cValue := C.float(value)
 
 	defer C.free(unsafe.Pointer(cName))
 	defer C.free(unsafe.Pointer(cProp))
 
-	C.gstSetPropFloat(p.cPipeline, cName, cProp, cValue)
+	return float32(C.gstGetPropFloat(p.cPipeline, cName, cProp))
 }
 
 func (p *Pipeline) SetEncodingRate(kind string, value64 uint64) {
@@ -206,6 +439,27 @@ func (p *Pipeline) SetEncodingRate(kind string, value64 uint64) {
 	}
 }
 
+// the video stream sent to other peers is reencoded by video_encoder_wet only if a video fx is applied
+// (otherwise incoming RTP packets are forwarded as is)
+func (p *Pipeline) IsVideoReencoded() bool {
+	return p.join.RecordingMode != "passthrough" && len(p.join.VideoFx) > 0
+}
+
+func (p *Pipeline) RequestKeyFrame() {
+	// throttled by encoder min-force-key-unit-interval, see config/gst.yml
+	if p.worker != nil {
+		p.worker.send(msgKeyFrame, nil)
+		return
+	}
+	if p.deleted() {
+		return
+	}
+	cName := C.CString("video_encoder_wet")
+	defer C.free(unsafe.Pointer(cName))
+
+	C.gstRequestKeyFrame(p.cPipeline, cName)
+}
+
 func (p *Pipeline) SetFxProp(name string, prop string, value float32) {
 	// fx prefix needed (added during pipeline initialization)
 	p.setPropFloat("client_"+name, prop, value)
@@ -213,42 +467,9 @@ func (p *Pipeline) SetFxProp(name string, prop string, value float32) {
 
 func (p *Pipeline) GetFxProp(name string, prop string) float32 {
 	// fx prefix needed (added during pipeline initialization)
-	cName := C.CString("client_" + name)
-	cProp := C.CString(prop)
-
-	defer C.free(unsafe.Pointer(cName))
-	defer C.free(unsafe.Pointer(cProp))
-
-	return float32(C.gstGetPropFloat(p.cPipeline, cName, cProp))
+	return p.getPropFloat("client_"+name, prop)
 }
 
 func (p *Pipeline) SetFxPolyProp(name string, prop string, kind string, value string) {
-	cName := C.CString("client_" + name)
-	cProp := C.CString(prop)
-
-	defer C.free(unsafe.Pointer(cName))
-	defer C.free(unsafe.Pointer(cProp))
-
-	switch kind {
-	case "float":
-		if v, err := strconv.ParseFloat(value, 32); err == nil {
-			cValue := C.float(float32(v))
-			C.gstSetPropFloat(p.cPipeline, cName, cProp, cValue)
-		}
-	case "double":
-		if v, err := strconv.ParseFloat(value, 64); err == nil {
-			cValue := C.double(v)
-			C.gstSetPropDouble(p.cPipeline, cName, cProp, cValue)
-		}
-	case "int":
-		if v, err := strconv.ParseInt(value, 10, 32); err == nil {
-			cValue := C.int(int32(v))
-			C.gstSetPropInt(p.cPipeline, cName, cProp, cValue)
-		}
-	case "uint64":
-		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
-			cValue := C.ulong(v)
-			C.gstSetPropUint64(p.cPipeline, cName, cProp, cValue)
-		}
-	}
+	p.setProp("client_"+name, prop, kind, value)
 }
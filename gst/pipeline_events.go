@@ -0,0 +1,71 @@
+package gst
+
+import "time"
+
+const eventsBuffer = 16
+
+// EventKind types the events a pipeline sends to its owner
+type EventKind string
+
+const (
+	// GStreamer error (or worker crash), the pipeline is then deleted
+	ErrorEvent EventKind = "error"
+	// end of stream reached while the pipeline had not been stopped, the pipeline is then deleted
+	EOSEvent EventKind = "eos"
+)
+
+type Event struct {
+	Kind     EventKind `json:"kind"`
+	Pipeline string    `json:"pipeline"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Events of the pipeline (see EventKind), the channel is closed when the pipeline is deleted. Events are
+// dropped if not read fast enough
+func (p *Pipeline) Events() <-chan Event {
+	return p.eventsCh
+}
+
+func (p *Pipeline) emit(kind EventKind, msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.eventsClosed {
+		return
+	}
+	select {
+	case p.eventsCh <- Event{kind, p.id, msg, time.Now()}:
+	default:
+	}
+}
+
+func (p *Pipeline) deleted() bool {
+	select {
+	case <-p.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// called once GStreamer pipeline has been deleted. Pipelines bound to tracks (see BindTrack) are
+// expected to end after being stopped or after an error, otherwise the EOS is reported
+func (p *Pipeline) end() {
+	p.mu.Lock()
+	unexpected := !p.startedAt.IsZero() && p.stoppedCount < 2 && len(p.errors) == 0 && p.audioOutput != nil
+	p.mu.Unlock()
+
+	if unexpected {
+		msg := "end of stream reached before pipeline was stopped"
+		p.logger.Error().Msg("pipeline_unexpected_eos")
+		p.addError(EOSEvent, msg)
+		p.emit(EOSEvent, msg)
+	}
+
+	p.mu.Lock()
+	p.eventsClosed = true
+	close(p.eventsCh)
+	close(p.messagesCh)
+	p.mu.Unlock()
+}
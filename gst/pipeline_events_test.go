@@ -0,0 +1,44 @@
+package gst
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestPipelineEvents(t *testing.T) {
+	newBound := func() *Pipeline {
+		return &Pipeline{
+			id:          "pipeline",
+			startedAt:   time.Now(),
+			audioOutput: &stallTrack{},
+			videoOutput: &stallTrack{},
+			doneCh:      make(chan struct{}),
+			eventsCh:    make(chan Event, eventsBuffer),
+			messagesCh:  make(chan Message, messagesBuffer),
+			logger:      zerolog.Nop(),
+		}
+	}
+
+	// EOS while tracks are still flowing
+	p := newBound()
+	p.end()
+	e, ok := <-p.Events()
+	if !ok || e.Kind != EOSEvent || len(p.Errors()) != 1 || p.Errors()[0].Kind != "eos" {
+		t.Errorf("unexpected EOS should be reported: %+v %+v", e, p.Errors())
+	}
+	if _, ok := <-p.Events(); ok {
+		t.Error("events should be closed")
+	}
+	// no more events once closed
+	p.emit(ErrorEvent, "late")
+
+	// EOS after stop
+	p = newBound()
+	p.stoppedCount = 2
+	p.end()
+	if _, ok := <-p.Events(); ok || len(p.Errors()) != 0 {
+		t.Error("expected EOS should not be reported")
+	}
+}
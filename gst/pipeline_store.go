@@ -2,8 +2,9 @@ package gst
 
 import (
 	"sync"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/creamlab/ducksoup/events"
 )
 
 var (
@@ -45,8 +46,60 @@ func (ps *pipelineStore) delete(id string) {
 
 	p, ok := ps.index[id]
 	if ok {
-		log.Info().Str("context", "pipeline").Str("namespace", p.join.Namespace).Str("room", p.join.RoomId).Str("user", p.join.UserId).Str("pipeline", id).Msg("pipeline_deleted")
+		p.end()
+		p.logger.Info().Msg("pipeline_deleted")
+		events.Publish(events.PipelineDeleted, p.join.Namespace, p.join.RoomId, p.join.UserId, map[string]interface{}{
+			"pipeline": id,
+		})
+		close(p.doneCh)
 	}
 
 	delete(ps.index, id)
 }
+
+// HasRoomPipelines tells if recordings of the given room may still be written
+func HasRoomPipelines(namespace, roomId string) bool {
+	pipelineStoreSingleton.Lock()
+	defer pipelineStoreSingleton.Unlock()
+
+	for _, p := range pipelineStoreSingleton.index {
+		if p.join.Namespace == namespace && p.join.RoomId == roomId {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps *pipelineStore) started() []*Pipeline {
+	ps.Lock()
+	defer ps.Unlock()
+
+	pipelines := []*Pipeline{}
+	for _, p := range ps.index {
+		if p.IsStarted() {
+			pipelines = append(pipelines, p)
+		}
+	}
+	return pipelines
+}
+
+// StopPipelines sends EOS to all started pipelines (for instance on server shutdown), so that their
+// recordings are finalized
+func StopPipelines() {
+	for _, p := range pipelineStoreSingleton.started() {
+		p.forceStop()
+	}
+}
+
+// WaitForPipelines blocks until started pipelines have been deleted or timeout, returning false in the latter case
+func WaitForPipelines(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for _, p := range pipelineStoreSingleton.started() {
+		select {
+		case <-p.Done():
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package gst
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/creamlab/ducksoup/types"
+)
+
+var ErrNoSegments = errors.New("no segments found")
+
+type segmentInput struct {
+	Index int
+	File  string
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+// Segments lists the files (relative to data folder and sorted) of a segmented recording, suffix being dry or wet
+func Segments(namespace, filePrefix, suffix string) ([]string, error) {
+	pattern := globEscaper.Replace("data/"+namespace+"/"+filePrefix+"-"+suffix) + "-[0-9][0-9][0-9][0-9][0-9].mkv"
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	for i, m := range matches {
+		matches[i] = strings.TrimPrefix(filepath.ToSlash(m), "data/")
+	}
+	return matches, nil
+}
+
+// ConcatenateSegments remuxes the segments of a recording into one file (named like a muxed
+// recording), blocking until done. Segments are removed unless keep is true or concatenation fails
+func ConcatenateSegments(join types.JoinPayload, filePrefix, suffix string, keep bool, logWriter io.Writer) (output string, segments []string, err error) {
+	segments, err = Segments(join.Namespace, filePrefix, suffix)
+	if err != nil {
+		return
+	}
+	if len(segments) == 0 {
+		err = ErrNoSegments
+		return
+	}
+
+	output = fileName(join.Namespace, filePrefix, suffix)
+	var inputs []segmentInput
+	for i, s := range segments {
+		inputs = append(inputs, segmentInput{Index: i, File: s})
+	}
+	data := struct {
+		Output   string
+		Segments []segmentInput
+	}{
+		output,
+		inputs,
+	}
+	var buf bytes.Buffer
+	if err = segmentsConcatTemplater.Execute(&buf, data); err != nil {
+		return
+	}
+
+	p := newPipeline(join, filePrefix, formatPipelineDef(buf), logWriter)
+	p.start()
+	<-p.Done()
+	if errs := p.Errors(); len(errs) > 0 {
+		os.Remove("data/" + output)
+		err = errors.New(errs[0].Message)
+		return
+	}
+	if !keep {
+		for _, s := range segments {
+			os.Remove("data/" + s)
+		}
+	}
+	return
+}
+
+func (p *Pipeline) IsSegmented() bool {
+	return p.join.RecordingMode == "segmented"
+}
+
+// ConcatenateSegments is called once a segmented pipeline is done (unless it timed out), it returns
+// the actual files of each expected recording file (see BindTrack): the concatenated file (and
+// segments if they are kept), or segments if concatenation failed or was skipped
+func (p *Pipeline) ConcatenateSegments(done bool) map[string][]string {
+	files := make(map[string][]string)
+	for _, suffix := range p.outputSuffixes() {
+		expected := fileName(p.join.Namespace, p.filePrefix, suffix)
+		if !done {
+			// last segment may still be written
+			segments, _ := Segments(p.join.Namespace, p.filePrefix, suffix)
+			files[expected] = segments
+			p.logger.Error().Str("file", expected).Msg("segments_concatenation_skipped")
+			continue
+		}
+		output, segments, err := ConcatenateSegments(p.join, p.filePrefix, suffix, config.Segments.Keep, p.logWriter)
+		if err != nil {
+			files[expected] = segments
+			p.logger.Error().Str("file", expected).Err(err).Msg("segments_concatenation_failed")
+			continue
+		}
+		files[expected] = []string{output}
+		if config.Segments.Keep {
+			files[expected] = append(files[expected], segments...)
+		}
+		p.logger.Info().Str("file", output).Int("count", len(segments)).Msg("segments_concatenated")
+	}
+	return files
+}
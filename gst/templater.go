@@ -10,19 +10,12 @@ import (
 	"bufio"
 	"bytes"
 	"strings"
+	"time"
 
 	"github.com/creamlab/ducksoup/types"
 )
 
-func newPipelineDef(join types.JoinPayload, filePrefix string) string {
-	audioCodec := config.Opus
-	// rely on the fact that assigning to a struct with only primitive values (string), is copying by value
-	// caution: don't extend codec type with non primitive values
-	if &audioCodec == &config.Opus {
-		panic("Unhandled audioCodec assign")
-	}
-	// choose videoCodec
-	var videoCodec codec
+func newVideoCodec(join types.JoinPayload) (videoCodec codec) {
 	switch join.VideoFormat {
 	case "VP8":
 		videoCodec = config.VP8
@@ -35,6 +28,31 @@ func newPipelineDef(join types.JoinPayload, filePrefix string) string {
 	default:
 		panic("Unhandled format " + join.VideoFormat)
 	}
+	return
+}
+
+// process lines (trim and remove blank lines)
+func formatPipelineDef(buf bytes.Buffer) string {
+	var formattedBuf bytes.Buffer
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	for scanner.Scan() {
+		trimmed := strings.TrimSpace(scanner.Text())
+		if len(trimmed) > 0 {
+			formattedBuf.WriteString(trimmed + "\n")
+		}
+	}
+	return formattedBuf.String()
+}
+
+func newPipelineDef(join types.JoinPayload, filePrefix string) string {
+	audioCodec := config.Opus
+	// rely on the fact that assigning to a struct with only primitive values (string), is copying by value
+	// caution: don't extend codec type with non primitive values
+	if &audioCodec == &config.Opus {
+		panic("Unhandled audioCodec assign")
+	}
+	// choose videoCodec
+	videoCodec := newVideoCodec(join)
 	// complete with Fx
 	audioCodec.Fx = strings.Replace(join.AudioFx, "name=", "name=client_", -1)
 	videoCodec.Fx = strings.Replace(join.VideoFx, "name=", "name=client_", -1)
@@ -48,6 +66,8 @@ func newPipelineDef(join types.JoinPayload, filePrefix string) string {
 		Width      int
 		Height     int
 		FrameRate  int
+		// segmented recording mode, in nanoseconds
+		SegmentDuration int64
 	}{
 		videoCodec,
 		audioCodec,
@@ -56,12 +76,15 @@ func newPipelineDef(join types.JoinPayload, filePrefix string) string {
 		join.Width,
 		join.Height,
 		join.FrameRate,
+		int64(config.Segments.Duration) * int64(time.Second),
 	}
 
 	// render pipeline from template
 	var buf bytes.Buffer
 	templater := muxedRecordingTemplater
-	if join.RecordingMode == "split" {
+	if join.RecordingMode == "segmented" {
+		templater = segmentedRecordingTemplater
+	} else if join.RecordingMode == "split" {
 		templater = splitRecordingTemplater
 	} else if join.RecordingMode == "passthrough" {
 		templater = passthroughTemplater
@@ -72,15 +95,5 @@ func newPipelineDef(join types.JoinPayload, filePrefix string) string {
 		panic(err)
 	}
 
-	// process lines (trim and remove blank lines)
-	var formattedBuf bytes.Buffer
-	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
-	for scanner.Scan() {
-		trimmed := strings.TrimSpace(scanner.Text())
-		if len(trimmed) > 0 {
-			formattedBuf.WriteString(trimmed + "\n")
-		}
-	}
-
-	return formattedBuf.String()
+	return formatPipelineDef(buf)
 }
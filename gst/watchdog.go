@@ -0,0 +1,127 @@
+package gst
+
+import (
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+)
+
+// StallHandler may be implemented by the output tracks bound to a pipeline (see BindTrack), to be
+// notified when the watchdog detects that the processing of their kind is stalled or has recovered
+type StallHandler interface {
+	PipelineStalled(stalled bool)
+}
+
+type trackHealth struct {
+	lastPushAt   time.Time
+	lastSampleAt time.Time
+	pushed       int // packets pushed since last sample
+	stalled      bool
+}
+
+func newHealthIndex() map[string]*trackHealth {
+	return map[string]*trackHealth{
+		"audio": {},
+		"video": {},
+	}
+}
+
+func (p *Pipeline) recordPush(kind string) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	if h, ok := p.health[kind]; ok {
+		h.lastPushAt = time.Now()
+		h.pushed++
+	}
+}
+
+func (p *Pipeline) recordSample(kind string) {
+	p.healthMu.Lock()
+	h, ok := p.health[kind]
+	if !ok {
+		p.healthMu.Unlock()
+		return
+	}
+	recovered := h.stalled
+	h.lastSampleAt = time.Now()
+	h.pushed = 0
+	h.stalled = false
+	p.healthMu.Unlock()
+
+	if recovered {
+		p.notifyHealth(kind, false, 0)
+	}
+}
+
+// a kind is stalled if packets are still pushed but no sample has been produced for stallTimeout
+// (since pipeline start for the first sample)
+func (p *Pipeline) checkHealth(now time.Time, stallTimeout time.Duration) {
+	startedAt := p.StartedAt()
+	stalled := map[string]int{}
+
+	p.healthMu.Lock()
+	for kind, h := range p.health {
+		if h.stalled || h.pushed == 0 {
+			continue
+		}
+		since := h.lastSampleAt
+		if since.Before(startedAt) {
+			since = startedAt
+		}
+		if now.Sub(h.lastPushAt) < stallTimeout && now.Sub(since) > stallTimeout {
+			h.stalled = true
+			stalled[kind] = h.pushed
+		}
+	}
+	p.healthMu.Unlock()
+
+	for kind, pushed := range stalled {
+		p.notifyHealth(kind, true, pushed)
+	}
+}
+
+func (p *Pipeline) notifyHealth(kind string, stalled bool, pushed int) {
+	if stalled {
+		pipelineStallCounter.WithLabelValues(kind).Inc()
+		p.logger.Error().Str("kind", kind).Int("pushed", pushed).Msg("pipeline_stalled")
+		events.Publish(events.PipelineStalled, p.join.Namespace, p.join.RoomId, p.join.UserId, map[string]interface{}{
+			"pipeline": p.id,
+			"kind":     kind,
+			"pushed":   pushed,
+		})
+	} else {
+		p.logger.Info().Str("kind", kind).Msg("pipeline_recovered")
+		events.Publish(events.PipelineRecovered, p.join.Namespace, p.join.RoomId, p.join.UserId, map[string]interface{}{
+			"pipeline": p.id,
+			"kind":     kind,
+		})
+	}
+
+	output := p.videoOutput
+	if kind == "audio" {
+		output = p.audioOutput
+	}
+	if h, ok := output.(StallHandler); ok {
+		h.PipelineStalled(stalled)
+	}
+}
+
+// watch checks pipeline health until it is done, see config/gst.yml
+func (p *Pipeline) watch() {
+	if config.Watchdog.Interval <= 0 || config.Watchdog.StallTimeout <= 0 {
+		return
+	}
+	stallTimeout := time.Duration(config.Watchdog.StallTimeout) * time.Millisecond
+	ticker := time.NewTicker(time.Duration(config.Watchdog.Interval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.doneCh:
+			return
+		case now := <-ticker.C:
+			p.checkHealth(now, stallTimeout)
+		}
+	}
+}
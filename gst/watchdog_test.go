@@ -0,0 +1,54 @@
+package gst
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type stallTrack struct {
+	stalled []bool
+}
+
+func (t *stallTrack) ID() string {
+	return "track"
+}
+
+func (t *stallTrack) Write(buf []byte) error {
+	return nil
+}
+
+func (t *stallTrack) PipelineStalled(stalled bool) {
+	t.stalled = append(t.stalled, stalled)
+}
+
+func TestWatchdog(t *testing.T) {
+	video := &stallTrack{}
+	p := &Pipeline{
+		id:          "pipeline",
+		startedAt:   time.Now().Add(-10 * time.Second),
+		audioOutput: &stallTrack{},
+		videoOutput: video,
+		health:      newHealthIndex(),
+		logger:      zerolog.Nop(),
+	}
+
+	p.recordPush("video")
+	p.checkHealth(time.Now(), 5*time.Second)
+	if len(video.stalled) != 1 || !video.stalled[0] {
+		t.Fatalf("video should be stalled: %v", video.stalled)
+	}
+	// notified once
+	p.checkHealth(time.Now(), 5*time.Second)
+	p.writeSample("video", nil)
+	if len(video.stalled) != 2 || video.stalled[1] {
+		t.Errorf("video should have recovered: %v", video.stalled)
+	}
+
+	// no input, no stall
+	p.checkHealth(time.Now().Add(time.Minute), 5*time.Second)
+	if len(video.stalled) != 2 {
+		t.Errorf("unexpected stall: %v", video.stalled)
+	}
+}
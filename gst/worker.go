@@ -0,0 +1,536 @@
+package gst
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+)
+
+// In worker mode (DS_GST_WORKERS=true), each pipeline runs in a child process (the ducksoup binary
+// launched with the worker command) so that a crashing GStreamer plugin does not take the whole
+// server down. The main process and the worker exchange length-prefixed messages over a unix
+// socket pair: RTP/RTCP and control calls one way, processed samples, logs and errors the other.
+
+// messages sent to worker
+const (
+	msgCreate byte = iota + 1
+	msgStart
+	msgStop
+	msgPushRTP
+	msgPushRTCP
+	msgSetProp
+	msgGetProp
+	msgKeyFrame
+)
+
+// messages sent by worker
+const (
+	msgSample byte = iota + 64
+	msgLogOutput
+	msgLog
+	msgError
+	msgPropValue
+	msgDeleted
+	msgBusMessage
+)
+
+const (
+	// the socket pair end inherited by the worker (first of cmd.ExtraFiles)
+	workerFd = 3
+	// bounds messages read from a possibly corrupted stream
+	maxMessageSize       = 16 << 20
+	workerReplyTimeout   = 1 * time.Second
+	workerOrphanTimeout  = 10 * time.Second
+	workerTerminateDelay = 5 * time.Second
+	workerQueueSize      = 512
+)
+
+var (
+	// a worker not reading its messages within this delay is considered blocked
+	workerWriteTimeout = 2 * time.Second
+)
+
+var errWorkerGone = errors.New("worker is blocked or has exited")
+
+var (
+	workersEnabled bool
+	// set in worker processes only
+	host *workerHost
+)
+
+func init() {
+	workersEnabled = strings.ToLower(helpers.Getenv("DS_GST_WORKERS")) == "true"
+}
+
+type workerSetup struct {
+	Id         string            `json:"id"`
+	Join       types.JoinPayload `json:"join"`
+	FilePrefix string            `json:"filePrefix"`
+	Definition string            `json:"definition"`
+}
+
+type workerProp struct {
+	Seq   uint32  `json:"seq"`
+	Name  string  `json:"name"`
+	Prop  string  `json:"prop"`
+	Kind  string  `json:"kind,omitempty"`
+	Value string  `json:"value,omitempty"`
+	Float float32 `json:"float,omitempty"`
+}
+
+// message: type (1 byte), payload length (4 bytes, big endian), payload
+func writeMessage(w io.Writer, t byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = t
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readMessage(r *bufio.Reader) (t byte, payload []byte, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	t = header[0]
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		err = fmt.Errorf("message too large: %v bytes", size)
+		return
+	}
+	payload = make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+// buffers are prefixed with their kind
+func kindPayload(kind string, buffer []byte) []byte {
+	return append([]byte{kind[0]}, buffer...)
+}
+
+func payloadKind(payload []byte) (kind string, buffer []byte, err error) {
+	if len(payload) == 0 {
+		return "", nil, errors.New("empty payload")
+	}
+	switch payload[0] {
+	case 'a':
+		kind = "audio"
+	case 'v':
+		kind = "video"
+	default:
+		return "", nil, fmt.Errorf("unknown kind: %v", payload[0])
+	}
+	return kind, payload[1:], nil
+}
+
+// concurrent writers (GStreamer threads...) share one connection in worker processes
+type workerConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *workerConn) send(t byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return writeMessage(c.conn, t, payload)
+}
+
+// Main process side
+
+type workerMessage struct {
+	t       byte
+	payload []byte
+}
+
+type worker struct {
+	conn net.Conn
+	cmd  *exec.Cmd
+	// messages are written by writeLoop, so that callers (mixer slices) are not blocked by a worker
+	queueCh chan workerMessage
+	// property reads are sequential, replies are matched by seq
+	getMu     sync.Mutex
+	seq       uint32
+	repliesCh chan workerProp
+	// closed if worker has been killed for not reading its messages
+	blockedCh chan struct{}
+	// closed once worker process has exited
+	exitedCh chan struct{}
+}
+
+func startWorker(p *Pipeline, pipelineStr string) (w *worker, err error) {
+	// other workers must not inherit this socket pair (the crash of this one would go unnoticed),
+	// ExtraFiles are inherited regardless of close-on-exec
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return
+	}
+	parentFile := os.NewFile(uintptr(fds[0]), "worker-parent")
+	childFile := os.NewFile(uintptr(fds[1]), "worker-child")
+	defer parentFile.Close()
+	defer childFile.Close()
+
+	conn, err := net.FileConn(parentFile)
+	if err != nil {
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	cmd := exec.Command(executable, "worker")
+	cmd.ExtraFiles = []*os.File{childFile}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		conn.Close()
+		return
+	}
+
+	w = &worker{
+		conn:      conn,
+		cmd:       cmd,
+		queueCh:   make(chan workerMessage, workerQueueSize),
+		repliesCh: make(chan workerProp, 8),
+		blockedCh: make(chan struct{}),
+		exitedCh:  make(chan struct{}),
+	}
+	setup, _ := json.Marshal(workerSetup{p.id, p.join, p.filePrefix, pipelineStr})
+	w.queueCh <- workerMessage{msgCreate, setup}
+	go w.writeLoop(p)
+	go w.listen(p)
+	return
+}
+
+// writes queued messages until the worker exits. A worker that does not read them in time (deadlocked
+// plugin...) is killed, which is then reported by listen
+func (w *worker) writeLoop(p *Pipeline) {
+	for {
+		select {
+		case m := <-w.queueCh:
+			w.conn.SetWriteDeadline(time.Now().Add(workerWriteTimeout))
+			err := writeMessage(w.conn, m.t, m.payload)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				p.logger.Error().Int("pid", w.cmd.Process.Pid).Msg("pipeline_worker_blocked")
+				close(w.blockedCh)
+				w.cmd.Process.Kill()
+				return
+			} else if err != nil {
+				// worker has exited
+				return
+			}
+		case <-w.exitedCh:
+			return
+		}
+	}
+}
+
+// control messages are not dropped, but an error is returned if the worker is blocked or has exited
+func (w *worker) send(t byte, payload []byte) error {
+	select {
+	case <-w.blockedCh:
+		return errWorkerGone
+	case <-w.exitedCh:
+		return errWorkerGone
+	default:
+	}
+	select {
+	case w.queueCh <- workerMessage{t, payload}:
+		return nil
+	case <-w.blockedCh:
+		return errWorkerGone
+	case <-w.exitedCh:
+		return errWorkerGone
+	case <-time.After(workerWriteTimeout):
+		return errWorkerGone
+	}
+}
+
+// forwards worker messages to the pipeline until the worker exits, which is reported as an error
+// if the GStreamer pipeline has not been deleted (crash)
+func (w *worker) listen(p *Pipeline) {
+	r := bufio.NewReader(w.conn)
+	deleted := false
+	var readErr error
+	for !deleted {
+		t, payload, err := readMessage(r)
+		if err != nil {
+			readErr = err
+			break
+		}
+		switch t {
+		case msgSample:
+			if kind, buffer, err := payloadKind(payload); err == nil {
+				p.writeSample(kind, buffer)
+			}
+		case msgLogOutput:
+			p.logWriter.Write(payload)
+		case msgLog, msgError:
+			p.log(string(payload), t == msgError)
+		case msgPropValue:
+			var prop workerProp
+			if err := json.Unmarshal(payload, &prop); err == nil {
+				select {
+				case w.repliesCh <- prop:
+				default:
+				}
+			}
+		case msgBusMessage:
+			if m, err := decodeMessage(payload); err == nil {
+				p.busMessage(m)
+			}
+		case msgDeleted:
+			deleted = true
+		}
+	}
+	w.conn.Close()
+
+	// a worker that is not done within delay is killed
+	exited := make(chan error, 1)
+	go func() {
+		exited <- w.cmd.Wait()
+	}()
+	var exitErr error
+	select {
+	case exitErr = <-exited:
+	case <-time.After(workerTerminateDelay):
+		w.cmd.Process.Kill()
+		exitErr = <-exited
+	}
+	close(w.exitedCh)
+
+	if !deleted {
+		if exitErr == nil {
+			exitErr = readErr
+		}
+		select {
+		case <-w.blockedCh:
+			p.log("pipeline worker blocked and killed", true)
+		default:
+			p.logger.Error().Err(exitErr).Int("pid", w.cmd.Process.Pid).Msg("pipeline_worker_crashed")
+			p.log(fmt.Sprintf("pipeline worker crashed: %v", exitErr), true)
+		}
+	}
+	pipelineStoreSingleton.delete(p.id)
+}
+
+// packets are dropped if the queue is full, errors are not returned: if the worker crashed or is
+// blocked, it is reported by listen
+func (w *worker) push(t byte, kind string, buffer []byte) {
+	select {
+	case w.queueCh <- workerMessage{t, kindPayload(kind, buffer)}:
+	default:
+	}
+}
+
+func (w *worker) setProp(name, prop, kind, value string) {
+	payload, _ := json.Marshal(workerProp{Name: name, Prop: prop, Kind: kind, Value: value})
+	w.send(msgSetProp, payload)
+}
+
+// returns 0 if worker does not reply in time
+func (w *worker) getPropFloat(name, prop string) float32 {
+	w.getMu.Lock()
+	defer w.getMu.Unlock()
+
+	w.seq++
+	payload, _ := json.Marshal(workerProp{Seq: w.seq, Name: name, Prop: prop})
+	if err := w.send(msgGetProp, payload); err != nil {
+		return 0
+	}
+	timeout := time.After(workerReplyTimeout)
+	for {
+		select {
+		case reply := <-w.repliesCh:
+			if reply.Seq == w.seq {
+				return reply.Float
+			}
+		case <-w.exitedCh:
+			return 0
+		case <-timeout:
+			return 0
+		}
+	}
+}
+
+// Worker process side
+
+type workerHost struct {
+	workerConn
+	deleteOnce sync.Once
+	deletedCh  chan struct{}
+}
+
+// logs of the pipeline are written by the main process (to the room log)
+func (h *workerHost) Write(buf []byte) (int, error) {
+	if err := h.send(msgLogOutput, buf); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (h *workerHost) log(msg string, isError bool) {
+	if isError {
+		h.send(msgError, []byte(msg))
+	} else {
+		h.send(msgLog, []byte(msg))
+	}
+}
+
+// p is marked as deleted first, so that messages still sent by the main process (or the orphan
+// stop) don't reach the freed GStreamer pipeline
+func (h *workerHost) deleted(p *Pipeline) {
+	h.deleteOnce.Do(func() {
+		if p != nil {
+			close(p.doneCh)
+		}
+		h.send(msgDeleted, nil)
+		close(h.deletedCh)
+	})
+}
+
+// output track of the worker pipeline: samples are sent to the main process
+type workerTrack struct {
+	id   string
+	kind string
+}
+
+func (t workerTrack) ID() string {
+	return t.id
+}
+
+func (t workerTrack) Write(buf []byte) error {
+	return host.send(msgSample, kindPayload(t.kind, buf))
+}
+
+func (h *workerHost) handle(p *Pipeline, t byte, payload []byte) {
+	switch t {
+	case msgStart:
+		p.startGst()
+	case msgStop:
+		p.stopGst()
+	case msgPushRTP, msgPushRTCP:
+		if kind, buffer, err := payloadKind(payload); err == nil {
+			if t == msgPushRTP {
+				p.PushRTP(kind, buffer)
+			} else {
+				p.PushRTCP(kind, buffer)
+			}
+		}
+	case msgSetProp:
+		var prop workerProp
+		if err := json.Unmarshal(payload, &prop); err == nil {
+			p.setProp(prop.Name, prop.Prop, prop.Kind, prop.Value)
+		}
+	case msgGetProp:
+		var prop workerProp
+		if err := json.Unmarshal(payload, &prop); err == nil {
+			prop.Float = p.getPropFloat(prop.Name, prop.Prop)
+			reply, _ := json.Marshal(prop)
+			h.send(msgPropValue, reply)
+		}
+	case msgKeyFrame:
+		p.RequestKeyFrame()
+	}
+}
+
+// RunWorker is the worker command, launched by the main process for each pipeline in worker mode. It
+// returns once the GStreamer pipeline has been deleted
+func RunWorker(args []string) error {
+	// signals are handled by the main process, that stops pipelines on shutdown
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM)
+
+	f := os.NewFile(workerFd, "worker")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("worker connection failed: %w", err)
+	}
+	defer conn.Close()
+	host = &workerHost{workerConn: workerConn{conn: conn}, deletedCh: make(chan struct{})}
+
+	r := bufio.NewReader(conn)
+	t, payload, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	var setup workerSetup
+	if t != msgCreate {
+		return errors.New("worker expects pipeline definition first")
+	}
+	if err := json.Unmarshal(payload, &setup); err != nil {
+		return err
+	}
+
+	go StartMainLoop()
+	defer StopMainLoop()
+
+	p := &Pipeline{
+		id:          setup.Id,
+		join:        setup.Join,
+		cPipeline:   parsePipeline(setup.Id, setup.Definition),
+		audioOutput: workerTrack{setup.Id, "audio"},
+		videoOutput: workerTrack{setup.Id, "video"},
+		filePrefix:  setup.FilePrefix,
+		doneCh:      make(chan struct{}),
+		eventsCh:    make(chan Event, eventsBuffer),
+		messagesCh:  make(chan Message, messagesBuffer),
+		health:      newHealthIndex(),
+		logger:      pipelineLogger(setup.Join, setup.Id, host),
+		logWriter:   host,
+	}
+	pipelineStoreSingleton.add(p)
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		for {
+			t, payload, err := readMessage(r)
+			if err != nil {
+				readErrCh <- err
+				return
+			}
+			if p.deleted() {
+				return
+			}
+			host.handle(p, t, payload)
+		}
+	}()
+
+	select {
+	case <-host.deletedCh:
+		return nil
+	case err := <-readErrCh:
+		// main process is gone: recordings are finalized before exiting
+		log.Error().Str("context", "pipeline").Str("pipeline", p.id).Err(err).Msg("pipeline_worker_orphaned")
+		p.stopGst()
+		select {
+		case <-host.deletedCh:
+		case <-time.After(workerOrphanTimeout):
+		}
+		return err
+	}
+}
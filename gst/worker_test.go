@@ -0,0 +1,73 @@
+package gst
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWorkerMessages(t *testing.T) {
+	var buf bytes.Buffer
+	writeMessage(&buf, msgPushRTP, kindPayload("video", []byte{1, 2, 3}))
+	writeMessage(&buf, msgStop, nil)
+
+	r := bufio.NewReader(&buf)
+	msgType, payload, err := readMessage(r)
+	if err != nil || msgType != msgPushRTP {
+		t.Fatalf("unexpected message: %v %v", msgType, err)
+	}
+	kind, rtp, err := payloadKind(payload)
+	if err != nil || kind != "video" || !bytes.Equal(rtp, []byte{1, 2, 3}) {
+		t.Errorf("unexpected payload: %v %v %v", kind, rtp, err)
+	}
+	if msgType, payload, err = readMessage(r); err != nil || msgType != msgStop || len(payload) != 0 {
+		t.Errorf("unexpected message: %v %v %v", msgType, payload, err)
+	}
+	if _, _, err = readMessage(r); err == nil {
+		t.Error("end of stream expected")
+	}
+}
+
+func TestBlockedWorker(t *testing.T) {
+	workerWriteTimeout = 50 * time.Millisecond
+	defer func() {
+		workerWriteTimeout = 2 * time.Second
+	}()
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep not available")
+	}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	w := &worker{
+		conn:      conn,
+		cmd:       cmd,
+		queueCh:   make(chan workerMessage, workerQueueSize),
+		blockedCh: make(chan struct{}),
+		exitedCh:  make(chan struct{}),
+	}
+	p := &Pipeline{logger: zerolog.Nop()}
+	go w.writeLoop(p)
+
+	// nothing is read from peer: pushes must not block, worker must be killed
+	for i := 0; i < 2*workerQueueSize; i++ {
+		w.push(msgPushRTP, "audio", []byte{1})
+	}
+	select {
+	case <-w.blockedCh:
+	case <-time.After(time.Second):
+		t.Fatal("worker should be considered blocked")
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("worker should have been killed")
+	}
+	if err := w.send(msgStop, nil); err != errWorkerGone {
+		t.Errorf("unexpected send result: %v", err)
+	}
+}
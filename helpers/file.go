@@ -2,7 +2,10 @@ package helpers
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/rs/zerolog/log"
@@ -43,3 +46,19 @@ func EnsureDir(path string) {
 		os.Mkdir(path, 0775)
 	}
 }
+
+// returns size and hex encoded SHA-256 checksum of file
+func FileChecksum(path string) (size int64, checksum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if size, err = io.Copy(h, f); err != nil {
+		return
+	}
+	checksum = hex.EncodeToString(h.Sum(nil))
+	return
+}
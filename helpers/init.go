@@ -42,19 +42,21 @@ func init() {
 	// file writer
 	logFile := Getenv("DS_LOG_FILE")
 	if logFile != "" {
-		fileWriter, fileErr := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		fileWriter, fileErr := OpenLogFile(logFile)
 		if fileErr != nil {
 			log.Error().Str("context", "init").Msg("error opening log file")
 		} else {
+			mainLogFile = fileWriter
 			writers = append(writers, fileWriter)
 		}
 	}
 	// set writers
 	if len(writers) == 1 {
-		log.Logger = log.Output(writers[0])
+		logWriter = writers[0]
+		log.Logger = log.Output(logWriter)
 	} else if len(writers) > 1 {
-		multi := zerolog.MultiLevelWriter(writers...)
-		log.Logger = log.Output(multi)
+		logWriter = zerolog.MultiLevelWriter(writers...)
+		log.Logger = log.Output(logWriter)
 	}
 	// set level
 	level := Getenv("DS_LOG_LEVEL")
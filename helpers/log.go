@@ -0,0 +1,95 @@
+package helpers
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	// global log output, as configured in init (zerolog defaults to Stderr)
+	logWriter io.Writer = os.Stderr
+	// DS_LOG_FILE if set
+	mainLogFile *LogFile
+)
+
+// LogFile is a log file that can be written to (and safely ignores writes) after being closed
+type LogFile struct {
+	sync.Mutex
+	f      *os.File
+	closed bool
+}
+
+func OpenLogFile(path string) (*LogFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{f: f}, nil
+}
+
+func (lf *LogFile) Write(p []byte) (n int, err error) {
+	lf.Lock()
+	defer lf.Unlock()
+
+	if lf.closed {
+		return len(p), nil
+	}
+	return lf.f.Write(p)
+}
+
+// Rewrite replaces the content of the log file by the result of rewrite, writes being blocked meanwhile
+func (lf *LogFile) Rewrite(rewrite func(content []byte) ([]byte, error)) error {
+	lf.Lock()
+	defer lf.Unlock()
+
+	if lf.closed {
+		return os.ErrClosed
+	}
+	content, err := os.ReadFile(lf.f.Name())
+	if err != nil {
+		return err
+	}
+	rewritten, err := rewrite(content)
+	if err != nil {
+		return err
+	}
+	// the file is opened in append mode: writes start again from the beginning once truncated
+	if err = lf.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = lf.f.Write(rewritten)
+	return err
+}
+
+// Name returns the path the log file has been opened with
+func (lf *LogFile) Name() string {
+	return lf.f.Name()
+}
+
+func (lf *LogFile) Close() error {
+	lf.Lock()
+	defer lf.Unlock()
+
+	if lf.closed {
+		return nil
+	}
+	lf.closed = true
+	return lf.f.Close()
+}
+
+// MainLogFile returns DS_LOG_FILE as opened by this process, nil if not set
+func MainLogFile() *LogFile {
+	return mainLogFile
+}
+
+func LogWriter() io.Writer {
+	return logWriter
+}
+
+// LogWriterWith returns a writer that sends logs both to the global output and to w
+func LogWriterWith(w io.Writer) io.Writer {
+	return zerolog.MultiLevelWriter(logWriter, w)
+}
@@ -0,0 +1,21 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const pseudonymLength = 24 // hex characters
+
+// Pseudonymize returns a keyed hash (HMAC-SHA256) of id if DS_PSEUDONYM_KEY is set, and id otherwise.
+// Pseudonyms are stable so that a reconnecting user gets the same one
+func Pseudonymize(id string) string {
+	key := Getenv("DS_PSEUDONYM_KEY")
+	if len(key) == 0 {
+		return id
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	return "p" + hex.EncodeToString(mac.Sum(nil))[:pseudonymLength]
+}
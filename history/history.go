@@ -0,0 +1,425 @@
+// Package history records rooms, participants and files in an embedded database (bbolt), so that
+// past sessions can be queried without parsing logs, including after restarts
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/report"
+	"github.com/creamlab/ducksoup/storage"
+	"github.com/creamlab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dataFolder   = "data/"
+	roomFilesKey = "_room" // see sfu filesIndex
+	keyFormat    = "20060102-150405.000"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrDisabled    = errors.New("history is not enabled")
+	sessionsBucket = []byte("sessions")
+	path           string
+	db             *bolt.DB
+	subscription   *events.Subscription
+	stoppedCh      = make(chan struct{})
+	// session id per namespace/room, only used by the events goroutine
+	current = make(map[string]string)
+)
+
+func init() {
+	path = helpers.GetenvOr("DS_HISTORY_FILE", dataFolder+"history.db")
+}
+
+func open() (*bolt.DB, error) {
+	d, err := bolt.Open(path, 0664, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = d.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Start opens the database (only one process may open it) and records events
+func Start() {
+	var err error
+	if db, err = open(); err != nil {
+		log.Error().Str("context", "init").Str("file", path).Err(err).Msg("history_open_failed")
+		return
+	}
+	if err = markInterrupted(); err != nil {
+		log.Error().Str("context", "history").Err(err).Msg("history_update_failed")
+	}
+	log.Info().Str("context", "init").Str("file", path).Msg("history_enabled")
+
+	subscription = events.Subscribe(
+		events.RoomCreated,
+		events.PeerJoined,
+		events.JoinRejected,
+		events.RoomStarted,
+		events.PeerDisconnected,
+		events.RoomEnded,
+		events.RoomDeleted,
+		events.PipelineError,
+		events.FilesReady,
+		events.FilesUploaded,
+	)
+	go func() {
+		defer close(stoppedCh)
+		for e := range subscription.C {
+			if err := apply(e); err != nil {
+				log.Error().Str("context", "history").Str("namespace", e.Namespace).Str("room", e.RoomId).Str("event", string(e.Kind)).Err(err).Msg("history_update_failed")
+			}
+		}
+	}()
+}
+
+// Stop records pending events and closes the database
+func Stop() {
+	if subscription == nil {
+		return
+	}
+	subscription.Unsubscribe()
+	<-stoppedCh
+	if err := db.Close(); err != nil {
+		log.Error().Str("context", "history").Err(err).Msg("history_close_failed")
+	}
+}
+
+func get(tx *bolt.Tx, id string) (*Session, error) {
+	value := tx.Bucket(sessionsBucket).Get([]byte(id))
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	var s Session
+	if err := json.Unmarshal(value, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func put(tx *bolt.Tx, s *Session) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(sessionsBucket).Put([]byte(s.Id), value)
+}
+
+// sessions (modified by f) are written after iteration, since bucket can't be modified meanwhile
+func forEach(tx *bolt.Tx, f func(s *Session) (modified bool)) error {
+	modified := []*Session{}
+	err := tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+		var s Session
+		if err := json.Unmarshal(v, &s); err != nil {
+			return err
+		}
+		if f(&s) {
+			modified = append(modified, &s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, s := range modified {
+		if err := put(tx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func update(id string, f func(s *Session)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		s, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		f(s)
+		return put(tx, s)
+	})
+}
+
+// sessions still in progress when server stopped won't be updated anymore
+func markInterrupted() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return forEach(tx, func(s *Session) bool {
+			if s.State == Created || s.State == Running || s.State == Ended {
+				s.State = Interrupted
+				return true
+			}
+			return false
+		})
+	})
+}
+
+func apply(e events.Event) error {
+	key := e.Namespace + "/" + e.RoomId
+	data, _ := e.Data.(map[string]interface{})
+
+	var id string
+	switch e.Kind {
+	case events.RoomCreated:
+		id, _ = data["session"].(string)
+		s := newSession(id, e.Namespace, e.RoomId, e.Time)
+		s.Origin, _ = data["origin"].(string)
+		s.Size, _ = data["size"].(int)
+		s.Duration, _ = data["duration"].(int)
+		current[key] = id
+		return db.Update(func(tx *bolt.Tx) error {
+			return put(tx, s)
+		})
+	case events.FilesReady:
+		// room may have been deleted (and created again) meanwhile
+		files, _ := e.Data.(events.FilesData)
+		id = files.Session
+	case events.FilesUploaded:
+		r, _ := e.Data.(storage.Report)
+		id = r.Session
+	default:
+		id = current[key]
+	}
+	if len(id) == 0 {
+		return nil
+	}
+
+	return update(id, func(s *Session) {
+		switch e.Kind {
+		case events.PeerJoined:
+			p := s.participant(e.UserId)
+			p.JoinedCount, _ = data["joinedCount"].(int)
+			p.Join, _ = data["payload"].(types.JoinPayload)
+			p.Connections = append(p.Connections, Connection{JoinedAt: e.Time})
+		case events.JoinRejected:
+			reason, _ := data["reason"].(string)
+			s.Rejections = append(s.Rejections, Rejection{Time: e.Time, UserId: e.UserId, Reason: reason})
+		case events.RoomStarted:
+			s.State = Running
+			s.StartedAt = e.Time
+			if startedAt, ok := data["startedAt"].(time.Time); ok {
+				s.StartedAt = startedAt
+			}
+		case events.PeerDisconnected:
+			s.participant(e.UserId).disconnect(e.Time)
+		case events.RoomEnded:
+			s.State = Ended
+			s.EndedAt = e.Time
+		case events.RoomDeleted:
+			if s.State == Created {
+				s.State = NotStarted
+			}
+			for _, p := range s.Participants {
+				p.disconnect(e.Time)
+			}
+		case events.PipelineError:
+			if message, ok := data["error"].(string); ok {
+				s.Errors = append(s.Errors, message)
+			}
+		case events.FilesReady:
+			files := e.Data.(events.FilesData)
+			for userId, paths := range files.Files {
+				if userId == roomFilesKey {
+					s.RoomFiles = paths
+				} else {
+					s.participant(userId).Files = paths
+				}
+			}
+			s.Manifest, s.Log, s.Complete = files.Manifest, files.Log, files.Complete
+			s.State = Finalized
+			s.FinalizedAt = e.Time
+			addQuality(s)
+		case events.FilesUploaded:
+			uploaded := e.Data.(storage.Report).Complete
+			s.Uploaded = &uploaded
+		}
+	})
+}
+
+// quality is summarized from room log, if not encrypted
+func addQuality(s *Session) {
+	if len(s.Log) == 0 || strings.HasSuffix(s.Log, encryption.Suffix) {
+		return
+	}
+	f, err := os.Open(dataFolder + s.Log)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sessions, _, err := report.Build(f, report.DefaultThresholds)
+	if err != nil || len(sessions) == 0 {
+		return
+	}
+	rs := sessions[len(sessions)-1]
+	s.Flags = rs.Flags
+	for userId, u := range rs.Users {
+		p, ok := s.Participants[userId]
+		if !ok {
+			continue
+		}
+		var lossRate *float64
+		if rate, ok := u.LossRate(); ok {
+			lossRate = &rate
+		}
+		p.Quality = &Quality{
+			AudioInBitrate:  u.AudioInBitrate.Mean(),
+			VideoInBitrate:  u.VideoInBitrate.Mean(),
+			AudioOutBitrate: u.AudioOutBitrate.Mean(),
+			VideoOutBitrate: u.VideoOutBitrate.Mean(),
+			VideoFps:        u.VideoFps.Mean(),
+			LossRate:        lossRate,
+			PLISent:         u.PLISent,
+			Errors:          u.Errors,
+			Flags:           u.Flags,
+		}
+	}
+}
+
+// Filter of Query, zero values match all sessions
+type Filter struct {
+	Namespace  string
+	From       time.Time // creation time
+	To         time.Time
+	UserIds    []string // sessions of any of these users
+	State      string
+	Successful bool
+}
+
+func (f Filter) matches(s *Session) bool {
+	if len(f.Namespace) > 0 && s.Namespace != f.Namespace {
+		return false
+	}
+	if len(f.State) > 0 && s.State != f.State {
+		return false
+	}
+	if f.Successful && !s.Successful() {
+		return false
+	}
+	if len(f.UserIds) > 0 {
+		found := false
+		for _, userId := range f.UserIds {
+			if _, ok := s.Participants[userId]; ok {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Query lists sessions by creation time, ids starting with it (in server local time, From and To
+// may be given in any location)
+func Query(f Filter) ([]*Session, error) {
+	if db == nil {
+		return nil, ErrDisabled
+	}
+	sessions := []*Session{}
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		k, v := c.First()
+		if !f.From.IsZero() {
+			k, v = c.Seek([]byte(f.From.In(time.Local).Format(keyFormat)))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !f.To.IsZero() && string(k) > f.To.In(time.Local).Format(keyFormat) {
+				break
+			}
+			var s Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if f.matches(&s) {
+				sessions = append(sessions, &s)
+			}
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func Get(id string) (s *Session, err error) {
+	if db == nil {
+		return nil, ErrDisabled
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		s, err = get(tx, id)
+		return err
+	})
+	return
+}
+
+func mentions(message string, userIds []string) bool {
+	for _, userId := range userIds {
+		if strings.Contains(message, "-u-"+userId+"-c-") {
+			return true
+		}
+	}
+	return false
+}
+
+// ForgetUser removes participants (and their join rejections and pipeline errors) matching userIds, returning the count
+// of updated sessions. Database is opened if it's not (for instance when server is stopped)
+func ForgetUser(userIds []string) (count int, err error) {
+	d := db
+	if d == nil {
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+			return 0, nil
+		}
+		if d, err = open(); err != nil {
+			return
+		}
+		defer d.Close()
+	}
+	err = d.Update(func(tx *bolt.Tx) error {
+		return forEach(tx, func(s *Session) (updated bool) {
+			for _, userId := range userIds {
+				if _, ok := s.Participants[userId]; ok {
+					delete(s.Participants, userId)
+					updated = true
+				}
+			}
+			rejections := []Rejection{}
+			for _, r := range s.Rejections {
+				if helpers.Contains(userIds, r.UserId) {
+					updated = true
+				} else {
+					rejections = append(rejections, r)
+				}
+			}
+			// pipeline errors may mention user files
+			messages := []string{}
+			for _, message := range s.Errors {
+				if mentions(message, userIds) {
+					updated = true
+				} else {
+					messages = append(messages, message)
+				}
+			}
+			s.Rejections, s.Errors = rejections, messages
+			if updated {
+				count++
+			}
+			return
+		})
+	})
+	return
+}
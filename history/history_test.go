@@ -0,0 +1,100 @@
+package history
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/types"
+)
+
+func TestApply(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	os.MkdirAll("data", 0775)
+
+	var err error
+	if db, err = open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+	}()
+
+	// session ids are formatted in server local time
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() {
+		time.Local = local
+	}()
+	createdAt := time.Date(2022, 3, 1, 10, 0, 0, 0, time.Local)
+	id := "20220301-100000.000-n-ns-r-room"
+	at := func(seconds int) time.Time {
+		return createdAt.Add(time.Duration(seconds) * time.Second)
+	}
+	for _, e := range []events.Event{
+		{Kind: events.RoomCreated, Time: at(0), Namespace: "ns", RoomId: "room", UserId: "u1", Data: map[string]interface{}{"session": id, "size": 2, "duration": 30}},
+		{Kind: events.PeerJoined, Time: at(0), Namespace: "ns", RoomId: "room", UserId: "u1", Data: map[string]interface{}{"joinedCount": 1, "payload": types.JoinPayload{UserId: "u1"}}},
+		{Kind: events.PeerJoined, Time: at(1), Namespace: "ns", RoomId: "room", UserId: "u2", Data: map[string]interface{}{"joinedCount": 1}},
+		{Kind: events.JoinRejected, Time: at(2), Namespace: "ns", RoomId: "room", UserId: "u3", Data: map[string]interface{}{"reason": "full"}},
+		{Kind: events.RoomStarted, Time: at(2), Namespace: "ns", RoomId: "room"},
+		{Kind: events.PeerDisconnected, Time: at(10), Namespace: "ns", RoomId: "room", UserId: "u2"},
+		{Kind: events.RoomEnded, Time: at(32), Namespace: "ns", RoomId: "room"},
+		{Kind: events.RoomDeleted, Time: at(35), Namespace: "ns", RoomId: "room"},
+		{Kind: events.FilesReady, Time: at(36), Namespace: "ns", RoomId: "room", Data: events.FilesData{
+			Session:  id,
+			Files:    map[string][]string{"u1": {"ns/u1-dry.mkv"}, "_room": {"ns/room.mkv"}},
+			Complete: true,
+		}},
+	} {
+		if err := apply(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != Finalized || !s.Successful() || s.Size != 2 || len(s.RoomFiles) != 1 || len(s.Rejections) != 1 {
+		t.Errorf("unexpected session: %+v", s)
+	}
+	u1, u2 := s.Participants["u1"], s.Participants["u2"]
+	if u1.Join.UserId != "u1" || len(u1.Files) != 1 || u1.Connections[0].Duration != 35 {
+		t.Errorf("unexpected participant: %+v", u1)
+	}
+	if u2.Connections[0].Duration != 9 {
+		t.Errorf("unexpected participant: %+v", u2)
+	}
+
+	for _, f := range []Filter{
+		{Namespace: "ns", UserIds: []string{"u2"}, Successful: true},
+		{From: createdAt.Add(-time.Hour), To: createdAt.Add(time.Hour)},
+		{From: time.Date(2022, 3, 1, 7, 30, 0, 0, time.UTC), To: time.Date(2022, 3, 1, 8, 30, 0, 0, time.UTC)},
+	} {
+		if sessions, _ := Query(f); len(sessions) != 1 {
+			t.Errorf("session should match %+v", f)
+		}
+	}
+	for _, f := range []Filter{
+		{Namespace: "other"},
+		{UserIds: []string{"u3"}},
+		{From: createdAt.Add(time.Hour)},
+		{From: time.Date(2022, 3, 1, 9, 30, 0, 0, time.UTC)},
+		{State: Running},
+	} {
+		if sessions, _ := Query(f); len(sessions) != 0 {
+			t.Errorf("session should not match %+v", f)
+		}
+	}
+
+	if count, err := ForgetUser([]string{"u1", "u3"}); count != 1 || err != nil {
+		t.Errorf("unexpected forget result: %v %v", count, err)
+	}
+	if s, _ = Get(id); len(s.Participants) != 1 || len(s.Rejections) != 0 {
+		t.Errorf("unexpected session after forget: %+v", s)
+	}
+}
@@ -0,0 +1,123 @@
+package history
+
+import (
+	"time"
+
+	"github.com/creamlab/ducksoup/types"
+)
+
+// Session states
+const (
+	Created     = "created"
+	Running     = "running"
+	Ended       = "ended"
+	Finalized   = "finalized"
+	NotStarted  = "not_started" // deleted before starting (not enough users joined)
+	Interrupted = "interrupted" // server stopped before the session was finalized
+)
+
+// Session records a room lifetime, from its creation to its post-processing
+type Session struct {
+	Id           string                  `json:"id"` // same as room log file name (without extension)
+	Namespace    string                  `json:"namespace"`
+	RoomId       string                  `json:"roomId"`
+	Origin       string                  `json:"origin"`
+	Size         int                     `json:"size"`
+	Duration     int                     `json:"duration"`
+	State        string                  `json:"state"`
+	CreatedAt    time.Time               `json:"createdAt"`
+	StartedAt    time.Time               `json:"startedAt,omitempty"`
+	EndedAt      time.Time               `json:"endedAt,omitempty"`
+	FinalizedAt  time.Time               `json:"finalizedAt,omitempty"`
+	Participants map[string]*Participant `json:"participants"` // per user id
+	Rejections   []Rejection             `json:"rejections"`
+	// files are relative to data folder
+	RoomFiles []string `json:"roomFiles"`
+	Manifest  string   `json:"manifest,omitempty"`
+	Log       string   `json:"log,omitempty"`
+	// false if some recordings may not be finalized
+	Complete bool `json:"complete"`
+	// nil if no storage backend is configured
+	Uploaded *bool    `json:"uploaded,omitempty"`
+	Errors   []string `json:"errors"`
+	// from room log, see report package
+	Flags []string `json:"flags"`
+}
+
+type Participant struct {
+	UserId      string            `json:"userId"`
+	Join        types.JoinPayload `json:"join"` // last join payload
+	JoinedCount int               `json:"joinedCount"`
+	Connections []Connection      `json:"connections"`
+	Files       []string          `json:"files"`
+	Quality     *Quality          `json:"quality,omitempty"`
+}
+
+type Connection struct {
+	JoinedAt       time.Time `json:"joinedAt"`
+	DisconnectedAt time.Time `json:"disconnectedAt,omitempty"`
+	Duration       float64   `json:"duration"` // seconds, 0 if not disconnected
+}
+
+type Rejection struct {
+	Time   time.Time `json:"time"`
+	UserId string    `json:"userId"`
+	Reason string    `json:"reason"` // full, duplicate or shutdown
+}
+
+// Quality summarizes room log metrics, bitrates in kbit/s
+type Quality struct {
+	AudioInBitrate  float64  `json:"audioInBitrate"`
+	VideoInBitrate  float64  `json:"videoInBitrate"`
+	AudioOutBitrate float64  `json:"audioOutBitrate"`
+	VideoOutBitrate float64  `json:"videoOutBitrate"`
+	VideoFps        float64  `json:"videoFps"`
+	LossRate        *float64 `json:"lossRate"` // nil if not available in log
+	PLISent         int      `json:"pliSent"`
+	Errors          int      `json:"errors"`
+	Flags           []string `json:"flags"`
+}
+
+// Successful if finalized with complete recordings and no quality flag
+func (s *Session) Successful() bool {
+	return s.State == Finalized && s.Complete && len(s.Flags) == 0
+}
+
+func newSession(id, namespace, roomId string, createdAt time.Time) *Session {
+	return &Session{
+		Id:           id,
+		Namespace:    namespace,
+		RoomId:       roomId,
+		State:        Created,
+		CreatedAt:    createdAt,
+		Participants: make(map[string]*Participant),
+		Rejections:   []Rejection{},
+		RoomFiles:    []string{},
+		Errors:       []string{},
+		Flags:        []string{},
+	}
+}
+
+func (s *Session) participant(userId string) *Participant {
+	p, ok := s.Participants[userId]
+	if !ok {
+		p = &Participant{
+			UserId:      userId,
+			Connections: []Connection{},
+			Files:       []string{},
+		}
+		s.Participants[userId] = p
+	}
+	return p
+}
+
+func (p *Participant) disconnect(at time.Time) {
+	if len(p.Connections) == 0 {
+		return
+	}
+	last := &p.Connections[len(p.Connections)-1]
+	if last.DisconnectedAt.IsZero() {
+		last.DisconnectedAt = at
+		last.Duration = at.Sub(last.JoinedAt).Seconds()
+	}
+}
@@ -2,11 +2,23 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/export"
 	"github.com/creamlab/ducksoup/front"
 	"github.com/creamlab/ducksoup/gst"
 	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/history"
+	"github.com/creamlab/ducksoup/recordings"
+	"github.com/creamlab/ducksoup/report"
+	"github.com/creamlab/ducksoup/retention"
 	"github.com/creamlab/ducksoup/server"
+	"github.com/creamlab/ducksoup/sfu"
+	"github.com/creamlab/ducksoup/storage"
+	"github.com/creamlab/ducksoup/webhooks"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,7 +35,57 @@ func init() {
 	helpers.EnsureDir("./data")
 }
 
+// commands other than running the server, for instance: ducksoup report <log file>
+func runCommand(name string, args []string) (found bool, err error) {
+	switch name {
+	case "report":
+		return true, report.Run(args)
+	case "retention":
+		return true, retention.Run(args)
+	case "forget":
+		return true, recordings.RunForget(args)
+	case "decrypt":
+		return true, encryption.RunDecrypt(args)
+	case "export":
+		return true, export.Run(args)
+	case "repair":
+		return true, recordings.RunRepair(args)
+	case "worker":
+		// internal, launched by the server for each pipeline if DS_GST_WORKERS=true
+		return true, gst.RunWorker(args)
+	}
+	return false, nil
+}
+
+// on SIGTERM or SIGINT, rooms are ended and recordings finalized before stopping the main loop,
+// a second signal exits right away
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Info().Str("context", "app").Str("signal", sig.String()).Msg("app_stopping")
+	go func() {
+		sig := <-signals
+		log.Error().Str("context", "app").Str("signal", sig.String()).Msg("app_killed")
+		os.Exit(1)
+	}()
+
+	sfu.Shutdown()
+	history.Stop()
+	gst.StopMainLoop()
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if found, err := runCommand(os.Args[1], os.Args[2:]); found {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// always build front (in watch mode or not, depending on DS_ENV value, see front/build.go)
 	front.Build()
 
@@ -36,11 +98,19 @@ func main() {
 			log.Info().Str("context", "app").Msg("app_ended")
 		}()
 
+		// events subscribers
+		webhooks.Start()
+		storage.Start()
+		retention.Start()
+		history.Start()
+
+		go handleSignals()
+
 		// launch http (with websockets) server
 		go server.ListenAndServe()
 		log.Info().Str("context", "app").Msg("app_started")
 
-		// start Glib main loop for GStreamer
+		// start Glib main loop for GStreamer (blocking until shutdown)
 		gst.StartMainLoop()
 	}
 }
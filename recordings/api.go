@@ -0,0 +1,428 @@
+package recordings
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/history"
+	"github.com/creamlab/ducksoup/sfu"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+type contextKey string
+
+const (
+	namespacesKey contextKey = "namespaces"
+	allNamespaces            = "*"
+)
+
+type credentials struct {
+	login      string
+	password   string
+	namespaces []string // allNamespaces for admin
+}
+
+var credentialsList []credentials
+
+func init() {
+	// admin has access to all namespaces
+	adminLogin := helpers.Getenv("DS_RECORDINGS_LOGIN")
+	adminPassword := helpers.Getenv("DS_RECORDINGS_PASSWORD")
+	if len(adminLogin) > 0 && len(adminPassword) > 0 {
+		credentialsList = append(credentialsList, credentials{adminLogin, adminPassword, []string{allNamespaces}})
+	}
+	// namespace:login:password,namespace:login:password...
+	for _, item := range strings.Split(helpers.Getenv("DS_NAMESPACE_CREDENTIALS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 3)
+		if len(parts) != 3 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			if len(item) > 0 {
+				log.Error().Str("context", "init").Msg("namespace_credentials_invalid")
+			}
+			continue
+		}
+		added := false
+		for i, c := range credentialsList {
+			if c.login == parts[1] && c.password == parts[2] {
+				credentialsList[i].namespaces = append(c.namespaces, parts[0])
+				added = true
+			}
+		}
+		if !added {
+			credentialsList = append(credentialsList, credentials{parts[1], parts[2], []string{parts[0]}})
+		}
+	}
+}
+
+// Enabled if at least one login is defined
+func Enabled() bool {
+	return len(credentialsList) > 0
+}
+
+func equal(a, b string) bool {
+	aHash := sha256.Sum256([]byte(a))
+	bHash := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(aHash[:], bHash[:]) == 1
+}
+
+// basic auth, the matching credentials namespaces are stored in request context
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login, password, ok := r.BasicAuth()
+		if ok {
+			for _, c := range credentialsList {
+				loginMatch := equal(login, c.login)
+				passwordMatch := equal(password, c.password)
+				if loginMatch && passwordMatch {
+					ctx := context.WithValue(r.Context(), namespacesKey, c.namespaces)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func allowed(r *http.Request, namespace string) bool {
+	namespaces, _ := r.Context().Value(namespacesKey).([]string)
+	return helpers.Contains(namespaces, allNamespaces) || helpers.Contains(namespaces, namespace)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound || err == history.ErrNotFound || os.IsNotExist(err) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	log.Error().Str("context", "recordings").Err(err).Msg("recordings_request_failed")
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// checks namespace access and returns namespace (or "" after writing an error)
+func namespaceParam(w http.ResponseWriter, r *http.Request) string {
+	return namespaceParamValue(w, r, mux.Vars(r)["namespace"])
+}
+
+func namespaceParamValue(w http.ResponseWriter, r *http.Request, namespace string) string {
+	if !ValidId(namespace) || !allowed(r, namespace) {
+		// don't tell if namespace exists
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return ""
+	}
+	return namespace
+}
+
+func listNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := Namespaces()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	visible := []string{}
+	for _, namespace := range namespaces {
+		if allowed(r, namespace) {
+			visible = append(visible, namespace)
+		}
+	}
+	writeJSON(w, visible)
+}
+
+type sessionSummary struct {
+	*Session
+	Active bool `json:"active"`
+}
+
+func listSessions(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	sessions, err := Sessions(namespace)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	summaries := []sessionSummary{}
+	for _, s := range sessions {
+		// manifest details are only given per session
+		s.Manifest = nil
+		summaries = append(summaries, sessionSummary{s, s.Active()})
+	}
+	writeJSON(w, summaries)
+}
+
+func getSession(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	s, err := FindSession(namespace, mux.Vars(r)["session"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, sessionSummary{s, s.Active()})
+}
+
+func deleteSession(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	id := mux.Vars(r)["session"]
+	s, err := FindSession(namespace, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if s.Active() {
+		http.Error(w, "Conflict: room is still running or recording", http.StatusConflict)
+		return
+	}
+	deleted, err := DeleteSession(namespace, id)
+	log.Info().Str("context", "recordings").Str("namespace", namespace).Str("session", id).Strs("files", deleted).Msg("recordings_session_deleted")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, deleted)
+}
+
+// accepts dates (2006-01-02) or times (RFC3339), a date "to" includes the whole day
+func parseTime(value string, to bool) (t time.Time, err error) {
+	if len(value) == 0 {
+		return
+	}
+	if t, err = time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if to {
+			t = t.Add(24*time.Hour - time.Millisecond)
+		}
+		return
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func listHistory(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	f := history.Filter{
+		Namespace:  namespace,
+		State:      r.FormValue("state"),
+		Successful: r.FormValue("successful") == "true",
+	}
+	var errFrom, errTo error
+	f.From, errFrom = parseTime(r.FormValue("from"), false)
+	f.To, errTo = parseTime(r.FormValue("to"), true)
+	if errFrom != nil || errTo != nil {
+		http.Error(w, "Bad Request: from and to must be dates (2006-01-02) or RFC3339 times", http.StatusBadRequest)
+		return
+	}
+	if user := r.FormValue("user"); len(user) > 0 {
+		f.UserIds = sfu.StoredUserIds(user)
+	}
+	sessions, err := history.Query(f)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, sessions)
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	s, err := history.Get(mux.Vars(r)["session"])
+	if err == nil && s.Namespace != namespace {
+		err = ErrNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, s)
+}
+
+// admin credentials are required, unless removal is restricted to a namespace
+func forgetUser(w http.ResponseWriter, r *http.Request) {
+	namespace := r.FormValue("namespace")
+	if len(namespace) > 0 {
+		if namespace = namespaceParamValue(w, r, namespace); len(namespace) == 0 {
+			return
+		}
+	} else if !allowed(r, allNamespaces) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	login, _, _ := r.BasicAuth()
+	record, err := ForgetUser(mux.Vars(r)["user"], namespace, login, helpers.Getenv("DS_LOG_FILE"))
+	if err == ErrActive {
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, record)
+}
+
+// supports range requests
+func downloadFile(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	path, err := FilePath(namespace, mux.Vars(r)["file"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(path)+`"`)
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+}
+
+// streams session files without buffering them, media files being already compressed
+func downloadArchive(w http.ResponseWriter, r *http.Request) {
+	namespace := namespaceParam(w, r)
+	if len(namespace) == 0 {
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar" {
+		http.Error(w, "Bad Request: format must be zip or tar", http.StatusBadRequest)
+		return
+	}
+	s, err := FindSession(namespace, mux.Vars(r)["session"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+s.Id+"."+format+`"`)
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(w, s)
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+		err = writeTar(w, s)
+	}
+	if err != nil {
+		// headers have been sent, the client gets a truncated archive
+		log.Error().Str("context", "recordings").Str("namespace", namespace).Str("session", s.Id).Err(err).Msg("recordings_archive_failed")
+	}
+}
+
+func openFile(namespace, name string) (*os.File, os.FileInfo, error) {
+	path, err := FilePath(namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func writeZip(w io.Writer, s *Session) error {
+	zw := zip.NewWriter(w)
+	for _, f := range s.Files {
+		header := &zip.FileHeader{
+			Name:     s.Id + "/" + f.Name,
+			Method:   zip.Store,
+			Modified: f.ModifiedAt,
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, _, err := openFile(s.Namespace, f.Name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTar(w io.Writer, s *Session) error {
+	tw := tar.NewWriter(w)
+	for _, f := range s.Files {
+		file, info, err := openFile(s.Namespace, f.Name)
+		if err != nil {
+			return err
+		}
+		// size is read when opening, since file may have changed since session was listed
+		header := &tar.Header{
+			Name:    s.Id + "/" + f.Name,
+			Mode:    0664,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if err = tw.WriteHeader(header); err == nil {
+			_, err = io.CopyN(tw, file, info.Size())
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Route declares API endpoints on router (expected to be a subrouter)
+func Route(router *mux.Router) {
+	router.Use(authenticate)
+	router.HandleFunc("/", listNamespaces).Methods("GET")
+	router.HandleFunc("/{namespace}/sessions", listSessions).Methods("GET")
+	router.HandleFunc("/{namespace}/sessions/{session}", getSession).Methods("GET")
+	router.HandleFunc("/{namespace}/sessions/{session}", deleteSession).Methods("DELETE")
+	router.HandleFunc("/{namespace}/sessions/{session}/archive", downloadArchive).Methods("GET")
+	router.HandleFunc("/{namespace}/files/{file:.+}", downloadFile).Methods("GET")
+	router.HandleFunc("/{namespace}/history", listHistory).Methods("GET")
+	router.HandleFunc("/{namespace}/history/{session}", getHistory).Methods("GET")
+	router.HandleFunc("/users/{user}", forgetUser).Methods("DELETE")
+}
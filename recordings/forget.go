@@ -0,0 +1,345 @@
+package recordings
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/history"
+	"github.com/creamlab/ducksoup/sfu"
+	"github.com/creamlab/ducksoup/storage"
+	"github.com/creamlab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+)
+
+const auditFile = "audit.log" // in data folder
+
+var ErrActive = errors.New("room is still running or recording")
+
+// ForgetRecord is appended as a JSON line to the audit log. It does not contain the user id (nor
+// removed file names that contain it) but a hash of it, to be able to check that a deletion request
+// has been processed
+type ForgetRecord struct {
+	Time            time.Time `json:"time"`
+	RequestedBy     string    `json:"requestedBy"`
+	Subject         string    `json:"subject"` // SHA256 of the (pseudonymized) user id
+	Namespaces      []string  `json:"namespaces"`
+	Sessions        []string  `json:"sessions"`
+	RemovedFiles    int       `json:"removedFiles"`
+	UpdatedFiles    []string  `json:"updatedFiles"` // manifests and logs, relative to data folder
+	RemovedLogLines int       `json:"removedLogLines"`
+	UpdatedHistory  int       `json:"updatedHistory"` // count of history sessions
+	Errors          []string  `json:"errors"`
+}
+
+func (record *ForgetRecord) addError(err error) {
+	record.Errors = append(record.Errors, err.Error())
+}
+
+type userMatcher struct {
+	ids []string
+}
+
+func (m userMatcher) matches(id string) bool {
+	return helpers.Contains(m.ids, id)
+}
+
+// log lines are related to user if one of their user properties ("user" or "toUser" for messages
+// about what is sent to a user) matches, or if they mention one of its files
+func (m userMatcher) matchesLine(line []byte) bool {
+	for _, id := range m.ids {
+		if bytes.Contains(line, []byte("-u-"+id+"-c-")) {
+			return true
+		}
+	}
+	var entry struct {
+		User   string `json:"user"`
+		ToUser string `json:"toUser"`
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return false
+	}
+	return m.matches(entry.User) || m.matches(entry.ToUser)
+}
+
+func (m userMatcher) involves(s *Session) bool {
+	for _, f := range s.Files {
+		if m.matches(f.User) {
+			return true
+		}
+	}
+	for _, u := range s.Users {
+		if m.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// write then rename so that file is either previous or new version
+func replaceFile(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0664); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// filterLines removes user lines from JSON-lines log content
+func filterLines(content []byte, m userMatcher) (kept []byte, removed int, err error) {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if m.matchesLine(scanner.Bytes()) {
+			removed++
+			continue
+		}
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), removed, scanner.Err()
+}
+
+// filterLog removes user lines from a JSON-lines log file, returning the count of removed lines
+func filterLog(path string, m userMatcher) (removed int, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	kept, removed, err := filterLines(content, m)
+	if err != nil || removed == 0 {
+		return
+	}
+	err = replaceFile(path, kept)
+	return
+}
+
+// filterMainLog filters the main log in place (writes being blocked) if it is written by this
+// process (API), or replaces it otherwise (command run while server is stopped)
+func filterMainLog(path string, m userMatcher) (removed int, err error) {
+	lf := helpers.MainLogFile()
+	if lf == nil || filepath.Clean(lf.Name()) != filepath.Clean(path) {
+		return filterLog(path, m)
+	}
+	err = lf.Rewrite(func(content []byte) ([]byte, error) {
+		kept, count, err := filterLines(content, m)
+		removed = count
+		return kept, err
+	})
+	return
+}
+
+// filterManifest removes user data from manifest, files being removed separately
+func filterManifest(path string, m userMatcher, removedFiles []string) error {
+	manifest, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	users := []types.ManifestUser{}
+	for _, u := range manifest.Users {
+		if !m.matches(u.UserId) {
+			users = append(users, u)
+		}
+	}
+	files := []types.ManifestFile{}
+	for _, f := range manifest.Files {
+		if !m.matches(f.UserId) && !helpers.Contains(removedFiles, f.Path) {
+			files = append(files, f)
+		}
+	}
+	manifestErrors := []types.ManifestError{}
+	for _, e := range manifest.Errors {
+		if !m.matches(e.UserId) {
+			manifestErrors = append(manifestErrors, e)
+		}
+	}
+	manifest.Users, manifest.Files, manifest.Errors = users, files, manifestErrors
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, data)
+}
+
+func (record *ForgetRecord) updated(path string) {
+	record.UpdatedFiles = append(record.UpdatedFiles, path)
+	if err := storage.Replace(path); err != nil {
+		record.addError(fmt.Errorf("remote update of %v failed: %w", path, err))
+	}
+}
+
+func (record *ForgetRecord) forgetInSession(s *Session, m userMatcher) {
+	prefix := s.Namespace + "/"
+	removed := []string{}
+	for _, f := range s.Files {
+		// room composite recordings show all users
+		if !m.matches(f.User) && f.Kind != "room" {
+			continue
+		}
+		path, err := FilePath(s.Namespace, f.Name)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			record.addError(errors.New("file removal failed"))
+			continue
+		}
+		removed = append(removed, prefix+f.Name)
+		record.RemovedFiles++
+		// error details are not kept since they may contain file name
+		if err := storage.Remove(prefix + f.Name); err != nil {
+			record.addError(errors.New("remote file removal failed"))
+		}
+	}
+	for _, f := range s.Files {
+		path := filepath.Join(namespaceFolder(s.Namespace), f.Name)
+		switch f.Kind {
+		case "log":
+			if f.Encrypted {
+				record.addError(errors.New("encrypted log can't be filtered: " + prefix + f.Name))
+				continue
+			}
+			count, err := filterLog(path, m)
+			if err != nil {
+				record.addError(err)
+			} else if count > 0 {
+				record.RemovedLogLines += count
+				record.updated(prefix + f.Name)
+			}
+		case "manifest":
+			if err := filterManifest(path, m, removed); err != nil {
+				record.addError(err)
+			} else {
+				record.updated(prefix + f.Name)
+			}
+		}
+	}
+}
+
+func appendAudit(record *ForgetRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dataFolder, auditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// ForgetUser removes every recording of user (and room composite recordings it appears in), and
+// removes user entries from manifests and room logs, in the given namespace or in all namespaces
+// if empty. Nothing is done if one of the user sessions is active. Unless mainLog is empty, user lines
+// are also removed from this log, which must not be written meanwhile by another process
+func ForgetUser(userId, namespace, requestedBy, mainLog string) (*ForgetRecord, error) {
+	m := userMatcher{sfu.StoredUserIds(userId)}
+	subject := sha256.Sum256([]byte(m.ids[0]))
+	record := &ForgetRecord{
+		Time:         time.Now(),
+		RequestedBy:  requestedBy,
+		Subject:      hex.EncodeToString(subject[:]),
+		Namespaces:   []string{},
+		Sessions:     []string{},
+		UpdatedFiles: []string{},
+		Errors:       []string{},
+	}
+
+	namespaces := []string{namespace}
+	if len(namespace) == 0 {
+		var err error
+		if namespaces, err = Namespaces(); err != nil {
+			return nil, err
+		}
+	}
+	// check all sessions before removing anything
+	var sessions []*Session
+	for _, ns := range namespaces {
+		nsSessions, err := Sessions(ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range nsSessions {
+			if !m.involves(s) {
+				continue
+			}
+			if s.Active() {
+				return nil, ErrActive
+			}
+			sessions = append(sessions, s)
+		}
+	}
+
+	for _, s := range sessions {
+		if !helpers.Contains(record.Namespaces, s.Namespace) {
+			record.Namespaces = append(record.Namespaces, s.Namespace)
+		}
+		record.Sessions = append(record.Sessions, s.Namespace+"/"+s.Id)
+		record.forgetInSession(s, m)
+	}
+	if count, err := history.ForgetUser(m.ids); err != nil {
+		record.addError(err)
+	} else {
+		record.UpdatedHistory = count
+	}
+	if len(mainLog) > 0 {
+		count, err := filterMainLog(mainLog, m)
+		if err != nil {
+			record.addError(err)
+		}
+		record.RemovedLogLines += count
+	}
+
+	logger := log.With().Str("context", "recordings").Str("subject", record.Subject).Logger()
+	if err := appendAudit(record); err != nil {
+		logger.Error().Err(err).Msg("audit_write_failed")
+		return record, err
+	}
+	logger.Info().
+		Int("removed", record.RemovedFiles).
+		Int("updated", len(record.UpdatedFiles)).
+		Int("errors", len(record.Errors)).
+		Msg("user_forgotten")
+	return record, nil
+}
+
+// RunForget is the forget command, intended to be run when server is stopped
+func RunForget(args []string) error {
+	fs := flag.NewFlagSet("forget", flag.ContinueOnError)
+	namespace := fs.String("namespace", "", "restrict to namespace (defaults to all namespaces)")
+	mainLog := fs.String("log", helpers.Getenv("DS_LOG_FILE"), "also remove user lines from this log file")
+	requestedBy := fs.String("requested-by", "command", "requester name written in audit log")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup forget [options] <user id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing user id")
+	}
+	if len(*namespace) > 0 && !ValidId(*namespace) {
+		return fmt.Errorf("invalid namespace: %v", *namespace)
+	}
+	record, err := ForgetUser(fs.Arg(0), *namespace, *requestedBy, *mainLog)
+	if record != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(record)
+	}
+	return err
+}
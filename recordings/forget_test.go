@@ -0,0 +1,58 @@
+package recordings
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestForgetUser(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	writeFiles(t, map[string]string{
+		"ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv": "dry",
+		"ns/20220301-100001.000-n-ns-r-room-1-u-user-b-c-1-dry.mkv": "dry",
+		"ns/20220301-100002.000-n-ns-r-room-1-room.mkv":             "room",
+		"ns/20220301-100002.000-n-ns-r-room-1-manifest.json":        `{"roomId":"room-1","users":[{"userId":"user-a"},{"userId":"user-b"}],"files":[{"path":"ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv","userId":"user-a"},{"path":"ns/20220301-100001.000-n-ns-r-room-1-u-user-b-c-1-dry.mkv","userId":"user-b"},{"path":"ns/20220301-100002.000-n-ns-r-room-1-room.mkv"}],"logFile":"ns/logs/20220301-095959.000-n-ns-r-room-1.log"}`,
+		"ns/logs/20220301-095959.000-n-ns-r-room-1.log": `{"user":"user-a","message":"peer_joined"}
+{"user":"user-b","message":"peer_joined"}
+{"message":"room_started"}
+{"user":"user-b","toUser":"user-a","message":"audio_out_bitrate"}
+{"file":"ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv","message":"file_uploaded"}
+`,
+		"ns/20220302-100000.000-n-ns-r-room-2-u-user-b-c-1-dry.mkv": "dry",
+	})
+	os.WriteFile("main.log", []byte(`{"user":"user-a","message":"peer_joined"}
+{"message":"server_started"}
+`), 0664)
+
+	record, err := ForgetUser("user-a", "", "test", "main.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Sessions) != 1 || record.RemovedFiles != 2 || record.RemovedLogLines != 4 || len(record.Errors) != 0 {
+		t.Errorf("unexpected record: %+v", record)
+	}
+
+	s, err := FindSession("ns", "20220301-100002.000-n-ns-r-room-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Users) != 1 || s.Users[0] != "user-b" || len(s.Manifest.Files) != 1 {
+		t.Errorf("unexpected session after forget: %+v", s)
+	}
+	log, _ := os.ReadFile("data/ns/logs/20220301-095959.000-n-ns-r-room-1.log")
+	if strings.Contains(string(log), "user-a") || !strings.Contains(string(log), "room_started") {
+		t.Errorf("unexpected log after forget: %v", string(log))
+	}
+	mainLog, _ := os.ReadFile("main.log")
+	if strings.Contains(string(mainLog), "user-a") || !strings.Contains(string(mainLog), "server_started") {
+		t.Errorf("unexpected main log after forget: %v", string(mainLog))
+	}
+	audit, _ := os.ReadFile("data/audit.log")
+	if !strings.Contains(string(audit), record.Subject) || strings.Contains(string(audit), "user-a") {
+		t.Errorf("unexpected audit: %v", string(audit))
+	}
+}
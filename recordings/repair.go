@@ -0,0 +1,249 @@
+package recordings
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/gst"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/storage"
+	"github.com/creamlab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+)
+
+// RepairResult describes the concatenation of the segments of one recording, or the encryption
+// of a recording left unencrypted
+type RepairResult struct {
+	Session  string   `json:"session"`
+	Output   string   `json:"output,omitempty"` // relative to data folder
+	Segments []string `json:"segments,omitempty"`
+	Source   string   `json:"source,omitempty"` // unencrypted file
+	Error    string   `json:"error,omitempty"`
+}
+
+// segments of one recording (same connection and kind), identified by its file prefix
+type segmentGroup struct {
+	filePrefix string
+	kind       string
+	file       File
+}
+
+func segmentGroups(s *Session) (groups []segmentGroup, encrypted bool) {
+	seen := make(map[string]bool)
+	for _, f := range s.Files {
+		if len(f.Segment) == 0 {
+			continue
+		}
+		if f.Encrypted {
+			encrypted = true
+			continue
+		}
+		base := filepath.Base(f.Name)
+		filePrefix := strings.TrimSuffix(base, "-"+f.Kind+"-"+f.Segment+filepath.Ext(base))
+		key := filePrefix + "-" + f.Kind
+		if seen[key] || concatenatedExists(s, f) {
+			continue
+		}
+		seen[key] = true
+		groups = append(groups, segmentGroup{filePrefix, f.Kind, f})
+	}
+	return
+}
+
+func concatenatedExists(s *Session, segment File) bool {
+	for _, f := range s.Files {
+		if len(f.Segment) == 0 && f.User == segment.User && f.Connection == segment.Connection && f.Kind == segment.Kind && f.Time == segment.Time {
+			return true
+		}
+	}
+	return false
+}
+
+// updateManifest lists output instead of removed files (segments, unless they are kept, or
+// unencrypted file). A log file is replaced as the manifest log file
+func updateManifest(s *Session, userId, output string, removed []string) error {
+	var path string
+	for _, f := range s.Files {
+		if f.Kind == "manifest" {
+			path = filepath.Join(namespaceFolder(s.Namespace), f.Name)
+		}
+	}
+	if s.Manifest == nil || len(path) == 0 {
+		return nil
+	}
+	if helpers.Contains(removed, s.Manifest.LogFile) {
+		s.Manifest.LogFile = output
+	} else {
+		size, checksum, err := helpers.FileChecksum(filepath.Join(dataFolder, output))
+		if err != nil {
+			return err
+		}
+		files := []types.ManifestFile{}
+		for _, f := range s.Manifest.Files {
+			if !helpers.Contains(removed, f.Path) {
+				files = append(files, f)
+			}
+		}
+		s.Manifest.Files = append(files, types.ManifestFile{Path: output, UserId: userId, Size: size, SHA256: checksum})
+	}
+
+	data, err := json.MarshalIndent(s.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(path, data); err != nil {
+		return err
+	}
+	return storage.Replace(strings.TrimPrefix(filepath.ToSlash(path), dataFolder+"/"))
+}
+
+func repairGroup(s *Session, g segmentGroup, keep bool) (result RepairResult) {
+	result.Session = s.Id
+	join := types.JoinPayload{Namespace: s.Namespace, RoomId: g.file.Room, UserId: g.file.User}
+	output, segments, err := gst.ConcatenateSegments(join, g.filePrefix, g.kind, keep, helpers.LogWriter())
+	result.Segments = segments
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	if output, err = encryption.EncryptFile(s.Namespace, output); err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Output = output
+	if err := storage.Replace(output); err != nil {
+		result.Error = err.Error()
+	}
+
+	removed := []string{}
+	if !keep {
+		removed = segments
+		for _, segment := range segments {
+			if err := storage.Remove(segment); err != nil {
+				result.Error = err.Error()
+			}
+		}
+	}
+	if err := updateManifest(s, g.file.User, output, removed); err != nil {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// recordings and logs left unencrypted (if waiting for pipelines timed out or encryption failed)
+func unencryptedFiles(s *Session) (files []File) {
+	if !encryption.Enabled(s.Namespace) {
+		return
+	}
+	for _, f := range s.Files {
+		if f.Encrypted || len(f.Segment) > 0 || f.Kind == "manifest" || f.Kind == "encoder_log" {
+			continue
+		}
+		files = append(files, f)
+	}
+	return
+}
+
+func encryptFile(s *Session, f File) (result RepairResult) {
+	result.Session = s.Id
+	result.Source = s.Namespace + "/" + f.Name
+	output, err := encryption.EncryptFile(s.Namespace, result.Source)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Output = output
+	if err := storage.Replace(output); err != nil {
+		result.Error = err.Error()
+	}
+	if err := storage.Remove(result.Source); err != nil {
+		result.Error = err.Error()
+	}
+	if err := updateManifest(s, f.User, output, []string{result.Source}); err != nil {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// Repair concatenates the segments of segmented recordings of namespace that have not been (for
+// instance after a crash), and encrypts files left unencrypted if encryption is enabled. Active
+// sessions and encrypted segments are skipped
+func Repair(namespace string, keep bool) (results []RepairResult, err error) {
+	sessions, err := Sessions(namespace)
+	if err != nil {
+		return
+	}
+	for _, s := range sessions {
+		groups, encrypted := segmentGroups(s)
+		unencrypted := unencryptedFiles(s)
+		logger := log.With().Str("context", "recordings").Str("namespace", namespace).Str("session", s.Id).Logger()
+		if encrypted {
+			logger.Error().Msg("encrypted_segments_skipped")
+		}
+		if len(groups) == 0 && len(unencrypted) == 0 {
+			continue
+		}
+		if s.Active() {
+			logger.Error().Err(ErrActive).Msg("session_repair_skipped")
+			continue
+		}
+		for _, f := range unencrypted {
+			result := encryptFile(s, f)
+			if len(result.Error) > 0 {
+				logger.Error().Str("file", result.Source).Str("error", result.Error).Msg("file_encryption_failed")
+			} else {
+				logger.Info().Str("file", result.Output).Msg("file_encrypted")
+			}
+			results = append(results, result)
+		}
+		for _, g := range groups {
+			result := repairGroup(s, g, keep)
+			if len(result.Error) > 0 {
+				logger.Error().Str("file", g.filePrefix+"-"+g.kind).Str("error", result.Error).Msg("session_repair_failed")
+			} else {
+				logger.Info().Str("file", result.Output).Int("count", len(result.Segments)).Msg("session_repaired")
+			}
+			results = append(results, result)
+		}
+	}
+	return
+}
+
+// RunRepair is the repair command, intended to be run when server is stopped
+func RunRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	keep := fs.Bool("keep-segments", false, "keep segments once concatenated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup repair [options] <namespace>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing namespace")
+	}
+	namespace := fs.Arg(0)
+	if !ValidId(namespace) {
+		return fmt.Errorf("invalid namespace: %v", namespace)
+	}
+
+	// concatenation pipelines need the GStreamer main loop
+	go gst.StartMainLoop()
+	defer gst.StopMainLoop()
+
+	results, err := Repair(namespace, *keep)
+	if results != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(results)
+	}
+	return err
+}
@@ -0,0 +1,331 @@
+package recordings
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/gst"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/sfu"
+	"github.com/creamlab/ducksoup/types"
+)
+
+const (
+	dataFolder     = "data"
+	manifestSuffix = "-manifest.json"
+	// files not listed in a manifest are grouped per room in sessions with this prefix
+	unmanagedPrefix = "unmanaged-r-"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ids are cleaned with [a-zA-Z0-9-_] by sfu
+	idRegexp   = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+	timeRegexp = regexp.MustCompile(`^(\d{8}-\d{6}\.\d{3})-n-`)
+	// with a segment index in segmented recording mode
+	userFileRegexp = regexp.MustCompile(`^(.+?)-u-(.+)-c-(\d+)-((?:audio-|video-)?(?:dry|wet))(?:-(\d{5}))?\.(\w+)$`)
+	// x264 multipass cache files, see config/gst.yml
+	encoderLogRegexp = regexp.MustCompile(`^(.+?)-u-(.+)-c-(\d+)\.x264_pass\..+\.log$`)
+	roomFileRegexp   = regexp.MustCompile(`^(.+)-room\.(\w+)$`)
+	manifestRegexp   = regexp.MustCompile(`^(.+)` + regexp.QuoteMeta(manifestSuffix) + `$`)
+	sessionIdRegexp  = regexp.MustCompile(`^(\d{8}-\d{6}\.\d{3}-n-.+|` + unmanagedPrefix + `.+)$`)
+)
+
+// File metadata is derived from DuckSoup file names
+type File struct {
+	Name       string    `json:"name"` // relative to namespace folder
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	Time       string    `json:"time,omitempty"` // from file name
+	Room       string    `json:"room,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Connection int       `json:"connection,omitempty"`
+	Kind       string    `json:"kind,omitempty"`    // dry, wet, audio-dry... room, manifest, log, encoder_log
+	Segment    string    `json:"segment,omitempty"` // index of a segmented recording file
+	Encrypted  bool      `json:"encrypted,omitempty"`
+}
+
+type Session struct {
+	Id        string          `json:"id"`
+	Namespace string          `json:"namespace"`
+	Room      string          `json:"room"`
+	StartedAt time.Time       `json:"startedAt,omitempty"`
+	EndedAt   time.Time       `json:"endedAt,omitempty"`
+	Users     []string        `json:"users"`
+	Size      int64           `json:"size"`
+	Files     []File          `json:"files"`
+	Manifest  *types.Manifest `json:"manifest,omitempty"`
+}
+
+func ValidId(id string) bool {
+	return idRegexp.MatchString(id)
+}
+
+func validSessionId(id string) bool {
+	return sessionIdRegexp.MatchString(id) && !strings.ContainsAny(id, "/\\")
+}
+
+func namespaceFolder(namespace string) string {
+	return filepath.Join(dataFolder, namespace)
+}
+
+// Namespaces lists namespace folders
+func Namespaces() ([]string, error) {
+	entries, err := os.ReadDir(dataFolder)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := []string{}
+	for _, e := range entries {
+		if e.IsDir() && ValidId(e.Name()) {
+			namespaces = append(namespaces, e.Name())
+		}
+	}
+	return namespaces, nil
+}
+
+func parseFile(namespace, name string, info os.FileInfo) File {
+	f := File{Name: name, Size: info.Size(), ModifiedAt: info.ModTime()}
+	base := filepath.Base(name)
+	if strings.HasSuffix(base, encryption.Suffix) {
+		f.Encrypted = true
+		base = strings.TrimSuffix(base, encryption.Suffix)
+	}
+	if strings.HasPrefix(name, "logs/") {
+		f.Kind = "log"
+	}
+	match := timeRegexp.FindStringSubmatch(base)
+	if match == nil {
+		return f
+	}
+	f.Time = match[1]
+	// namespace is known, the remaining part starts with room
+	rest := strings.TrimPrefix(base, match[0]+namespace+"-r-")
+	if rest == base {
+		return f
+	}
+	if m := userFileRegexp.FindStringSubmatch(rest); m != nil {
+		f.Room, f.User, f.Kind, f.Segment = m[1], m[2], m[4], m[5]
+		f.Connection, _ = strconv.Atoi(m[3])
+	} else if m := encoderLogRegexp.FindStringSubmatch(rest); m != nil && f.Kind == "log" {
+		f.Room, f.User, f.Kind = m[1], m[2], "encoder_log"
+		f.Connection, _ = strconv.Atoi(m[3])
+	} else if m := manifestRegexp.FindStringSubmatch(rest); m != nil {
+		f.Room, f.Kind = m[1], "manifest"
+	} else if m := roomFileRegexp.FindStringSubmatch(rest); m != nil {
+		f.Room, f.Kind = m[1], "room"
+	} else if f.Kind == "log" {
+		f.Room = strings.TrimSuffix(rest, filepath.Ext(rest))
+	}
+	return f
+}
+
+// lists regular files of namespace folder and of its logs folder, indexed by name
+func namespaceFiles(namespace string) (map[string]File, error) {
+	files := make(map[string]File)
+	for _, folder := range []string{"", "logs"} {
+		entries, err := os.ReadDir(filepath.Join(namespaceFolder(namespace), folder))
+		if err != nil {
+			if folder == "logs" && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), ".tmp") {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			name := e.Name()
+			if folder != "" {
+				name = folder + "/" + name
+			}
+			files[name] = parseFile(namespace, name, info)
+		}
+	}
+	return files, nil
+}
+
+func readManifest(path string) (*types.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m types.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *Session) add(f File) {
+	s.Files = append(s.Files, f)
+	s.Size += f.Size
+	if len(f.User) > 0 && !helpers.Contains(s.Users, f.User) {
+		s.Users = append(s.Users, f.User)
+	}
+}
+
+// Sessions groups namespace files: one session per manifest (room that has been started and
+// finalized), and one "unmanaged" session per room for remaining files
+func Sessions(namespace string) ([]*Session, error) {
+	files, err := namespaceFiles(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*Session{}
+	unmanaged := make(map[string]*Session)
+	prefix := namespace + "/"
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// sessions with manifest
+	for _, name := range names {
+		f := files[name]
+		if f.Kind != "manifest" {
+			continue
+		}
+		s := &Session{
+			Id:        strings.TrimSuffix(name, manifestSuffix),
+			Namespace: namespace,
+			Room:      f.Room,
+			Users:     []string{},
+		}
+		s.add(f)
+		delete(files, name)
+		if m, err := readManifest(filepath.Join(namespaceFolder(namespace), name)); err == nil {
+			s.Manifest = m
+			s.StartedAt = m.StartedAt
+			s.EndedAt = m.EndedAt
+			paths := []string{}
+			for _, mf := range m.Files {
+				paths = append(paths, mf.Path)
+			}
+			if len(m.LogFile) > 0 {
+				paths = append(paths, m.LogFile)
+			}
+			for _, path := range paths {
+				fileName := strings.TrimPrefix(path, prefix)
+				if listed, ok := files[fileName]; ok {
+					s.add(listed)
+					delete(files, fileName)
+				}
+			}
+			for _, u := range m.Users {
+				if !helpers.Contains(s.Users, u.UserId) {
+					s.Users = append(s.Users, u.UserId)
+				}
+			}
+		}
+		sessions = append(sessions, s)
+	}
+
+	// remaining files
+	for _, name := range names {
+		f, ok := files[name]
+		if !ok {
+			continue
+		}
+		room := f.Room
+		if len(room) == 0 {
+			room = "unknown"
+		}
+		s, ok := unmanaged[room]
+		if !ok {
+			s = &Session{
+				Id:        unmanagedPrefix + room,
+				Namespace: namespace,
+				Room:      room,
+				Users:     []string{},
+			}
+			unmanaged[room] = s
+			sessions = append(sessions, s)
+		}
+		s.add(f)
+	}
+
+	for _, s := range sessions {
+		sort.Strings(s.Users)
+	}
+	return sessions, nil
+}
+
+// Unmanaged sessions group files that are not listed in a manifest
+func (s *Session) Unmanaged() bool {
+	return strings.HasPrefix(s.Id, unmanagedPrefix)
+}
+
+// Active if room is still running or recording
+func (s *Session) Active() bool {
+	return sfu.IsRoomActive(s.Namespace, s.Room) || gst.HasRoomPipelines(s.Namespace, s.Room)
+}
+
+func FindSession(namespace, id string) (*Session, error) {
+	if !validSessionId(id) {
+		return nil, ErrNotFound
+	}
+	sessions, err := Sessions(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if s.Id == id {
+			return s, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// FilePath checks that name is a file of namespace folder (or of its logs folder) and returns its path
+func FilePath(namespace, name string) (string, error) {
+	clean := filepath.ToSlash(filepath.Clean(name))
+	if clean != name || strings.HasPrefix(clean, "../") || strings.HasPrefix(clean, "/") || clean == ".." {
+		return "", ErrNotFound
+	}
+	dir, base := filepath.Split(clean)
+	if (dir != "" && dir != "logs/") || len(base) == 0 {
+		return "", ErrNotFound
+	}
+	path := filepath.Join(namespaceFolder(namespace), clean)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+// DeleteSession removes all session files, returning the deleted file names
+func DeleteSession(namespace, id string) ([]string, error) {
+	s, err := FindSession(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for _, f := range s.Files {
+		path, err := FilePath(namespace, f.Name)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, f.Name)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,66 @@
+package recordings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dataFolder, name)
+		os.MkdirAll(filepath.Dir(path), 0775)
+		if err := os.WriteFile(path, []byte(content), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	writeFiles(t, map[string]string{
+		"ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv":                  "dry",
+		"ns/20220301-100001.000-n-ns-r-room-1-u-user-b-c-1-dry.mkv":                  "dry",
+		"ns/20220301-100002.000-n-ns-r-room-1-manifest.json":                         `{"roomId":"room-1","files":[{"path":"ns/20220301-100000.000-n-ns-r-room-1-u-user-a-c-1-dry.mkv"},{"path":"ns/20220301-100001.000-n-ns-r-room-1-u-user-b-c-1-dry.mkv"}],"logFile":"ns/logs/20220301-095959.000-n-ns-r-room-1.log"}`,
+		"ns/logs/20220301-095959.000-n-ns-r-room-1.log":                              "{}",
+		"ns/20220301-110000.000-n-ns-r-room-1-u-user-a-c-1-audio-dry.ogg":            "dry",
+		"ns/logs/20220301-110000.000-n-ns-r-room-1-u-user-a-c-1.x264_pass.video.log": "pass",
+	})
+
+	sessions, err := Sessions("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %v", len(sessions))
+	}
+	managed := sessions[0]
+	if managed.Id != "20220301-100002.000-n-ns-r-room-1" || len(managed.Files) != 4 {
+		t.Errorf("unexpected session: %+v", managed)
+	}
+	if len(managed.Users) != 2 || managed.Users[0] != "user-a" {
+		t.Errorf("unexpected users: %v", managed.Users)
+	}
+	unmanaged := sessions[1]
+	if unmanaged.Id != "unmanaged-r-room-1" || len(unmanaged.Files) != 2 || unmanaged.Files[0].Kind != "audio-dry" || unmanaged.Files[1].Kind != "encoder_log" {
+		t.Errorf("unexpected session: %+v", unmanaged)
+	}
+
+	segment := "20220301-120000.000-n-other-r-room-1-u-user-a-c-2-wet-00003.mkv"
+	writeFiles(t, map[string]string{"other/" + segment: "wet"})
+	info, _ := os.Stat(filepath.Join(dataFolder, "other", segment))
+	f := parseFile("other", segment, info)
+	if f.Kind != "wet" || f.Segment != "00003" || f.User != "user-a" || f.Connection != 2 {
+		t.Errorf("unexpected segment file: %+v", f)
+	}
+
+	if _, err := FilePath("ns", "../ns/logs/20220301-095959.000-n-ns-r-room-1.log"); err != ErrNotFound {
+		t.Error("path traversal should be rejected")
+	}
+	if _, err := FilePath("ns", "logs/20220301-095959.000-n-ns-r-room-1.log"); err != nil {
+		t.Error("log file should be found")
+	}
+}
@@ -0,0 +1,57 @@
+package report
+
+// Thresholds used to flag sessions and users that may be excluded from analysis
+type Thresholds struct {
+	MinVideoBitrate  float64 // kbit/s, mean of incoming video
+	MinFps           float64 // mean of client video fps
+	MaxLossRate      float64 // incoming packets lost ratio
+	MaxUntrackedRate float64 // video frames with no face tracked ratio
+}
+
+var DefaultThresholds = Thresholds{
+	MinVideoBitrate:  300,
+	MinFps:           20,
+	MaxLossRate:      0.02,
+	MaxUntrackedRate: 0.1,
+}
+
+func (u *User) flag(t Thresholds) {
+	u.Flags = []string{}
+	if u.Reconnections > 0 {
+		u.Flags = append(u.Flags, "reconnected")
+	}
+	if len(u.VideoInBitrate) > 0 && u.VideoInBitrate.Mean() < t.MinVideoBitrate {
+		u.Flags = append(u.Flags, "low_video_bitrate")
+	}
+	if len(u.VideoFps) > 0 && u.VideoFps.Mean() < t.MinFps {
+		u.Flags = append(u.Flags, "low_fps")
+	}
+	if rate, ok := u.LossRate(); (ok && rate > t.MaxLossRate) || u.LossThresholdExceeded > 0 {
+		u.Flags = append(u.Flags, "high_loss")
+	}
+	if u.UntrackedRate() > t.MaxUntrackedRate {
+		u.Flags = append(u.Flags, "tracking_lost")
+	}
+	if u.Errors > 0 {
+		u.Flags = append(u.Flags, "errors")
+	}
+}
+
+func (s *Session) flag(t Thresholds) {
+	s.Flags = []string{}
+	if len(s.StartedAt) == 0 {
+		s.Flags = append(s.Flags, "not_started")
+	} else if len(s.EndedAt) == 0 {
+		s.Flags = append(s.Flags, "not_ended")
+	}
+	flaggedUser := false
+	for _, u := range s.Users {
+		u.flag(t)
+		if len(u.Flags) > 0 {
+			flaggedUser = true
+		}
+	}
+	if flaggedUser {
+		s.Flags = append(s.Flags, "user_flagged")
+	}
+}
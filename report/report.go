@@ -0,0 +1,205 @@
+// Package report turns DuckSoup JSON logs into per-session (room) and per-user summaries
+package report
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const maxLineSize = 1024 * 1024
+
+func readEntries(r io.Reader) (entries []logEntry, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e logEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			// not JSON (pretty-printed logs for instance)
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	err = scanner.Err()
+	return
+}
+
+// Build parses JSON logs (as written to DS_LOG_FILE or room log files) and returns flagged sessions
+func Build(r io.Reader, t Thresholds) (sessions []*Session, skipped int, err error) {
+	entries, skipped, err := readEntries(r)
+	if err != nil {
+		return
+	}
+	sessions = parse(entries)
+	for _, s := range sessions {
+		s.flag(t)
+	}
+	return
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// empty cells when packets stats are not available
+func formatPackets(u *User) (count, lost, rate string) {
+	if r, ok := u.LossRate(); ok {
+		return strconv.FormatUint(*u.PacketsCount, 10), strconv.FormatUint(*u.PacketsLost, 10), formatFloat(r)
+	}
+	return
+}
+
+// one row per session user
+func writeSummaryCSV(w io.Writer, sessions []*Session) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"namespace", "room", "createdAt", "startedAt", "endedAt", "durationSeconds", "sessionFlags",
+		"user", "joinedCount", "reconnections",
+		"audioInKbsMean", "videoInKbsMean", "videoInKbsMin", "audioOutKbsMean", "videoOutKbsMean", "videoFpsMean",
+		"packetsCount", "packetsLost", "lossRate", "lossThresholdExceeded",
+		"pliSent", "pliSkipped", "keyFrameRequests", "fxChanges", "untrackedRate", "errors", "userFlags",
+	})
+	for _, s := range sessions {
+		for _, userId := range s.SortedUserIds() {
+			u := s.Users[userId]
+			packetsCount, packetsLost, lossRate := formatPackets(u)
+			cw.Write([]string{
+				s.Namespace, s.Room, s.CreatedAt, s.StartedAt, s.EndedAt, formatFloat(s.Duration().Seconds()), strings.Join(s.Flags, "|"),
+				u.UserId, strconv.Itoa(u.JoinedCount), strconv.Itoa(u.Reconnections),
+				formatFloat(u.AudioInBitrate.Mean()), formatFloat(u.VideoInBitrate.Mean()), formatFloat(u.VideoInBitrate.Min()),
+				formatFloat(u.AudioOutBitrate.Mean()), formatFloat(u.VideoOutBitrate.Mean()), formatFloat(u.VideoFps.Mean()),
+				packetsCount, packetsLost, lossRate, strconv.Itoa(u.LossThresholdExceeded),
+				strconv.Itoa(u.PLISent), strconv.Itoa(u.PLISkipped), strconv.Itoa(u.KeyFrameRequests), strconv.Itoa(u.FxChanges),
+				formatFloat(u.UntrackedRate()), strconv.Itoa(u.Errors), strings.Join(u.Flags, "|"),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// long format: one row per sample
+func writeSeriesCSV(w io.Writer, sessions []*Session) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"namespace", "room", "createdAt", "user", "metric", "time", "value"})
+	for _, s := range sessions {
+		for _, userId := range s.SortedUserIds() {
+			u := s.Users[userId]
+			metrics := []struct {
+				name   string
+				series Series
+			}{
+				{"audio_in_kbs", u.AudioInBitrate},
+				{"video_in_kbs", u.VideoInBitrate},
+				{"audio_out_kbs", u.AudioOutBitrate},
+				{"video_out_kbs", u.VideoOutBitrate},
+				{"video_fps", u.VideoFps},
+			}
+			for _, m := range metrics {
+				for _, sample := range m.series {
+					cw.Write([]string{s.Namespace, s.Room, s.CreatedAt, userId, m.name, sample.Time, formatFloat(sample.Value)})
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Run implements the "report" command
+func Run(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format: json or csv")
+	out := fs.String("out", "", "output folder (defaults to stdout, CSV time series are only written to folder)")
+	t := DefaultThresholds
+	fs.Float64Var(&t.MinVideoBitrate, "min-video-bitrate", t.MinVideoBitrate, "flag users whose mean incoming video bitrate (kbit/s) is lower")
+	fs.Float64Var(&t.MinFps, "min-fps", t.MinFps, "flag users whose mean video fps is lower")
+	fs.Float64Var(&t.MaxLossRate, "max-loss-rate", t.MaxLossRate, "flag users whose incoming packets loss rate is higher")
+	fs.Float64Var(&t.MaxUntrackedRate, "max-untracked-rate", t.MaxUntrackedRate, "flag users whose ratio of frames with no face tracked is higher")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup report [options] <log file>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing log file")
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format: %v", *format)
+	}
+
+	// concatenate logs
+	var readers []io.Reader
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f, strings.NewReader("\n"))
+	}
+	sessions, skipped, err := Build(io.MultiReader(readers...), t)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d non JSON lines skipped\n", skipped)
+	}
+
+	writeJSON := func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sessions)
+	}
+	writeSummary := func(w io.Writer) error {
+		return writeSummaryCSV(w, sessions)
+	}
+	writeSeries := func(w io.Writer) error {
+		return writeSeriesCSV(w, sessions)
+	}
+
+	if len(*out) == 0 {
+		if *format == "json" {
+			return writeJSON(os.Stdout)
+		}
+		return writeSummary(os.Stdout)
+	}
+	if err := os.MkdirAll(*out, 0775); err != nil {
+		return err
+	}
+	if *format == "json" {
+		return writeFile(filepath.Join(*out, "report.json"), writeJSON)
+	}
+	if err := writeFile(filepath.Join(*out, "summary.csv"), writeSummary); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(*out, "series.csv"), writeSeries)
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+const logs = `{"level":"info","context":"room","namespace":"ns","room":"r1","user":"u1","time":"20220301-100000.000","message":"room_created"}
+{"level":"info","context":"peer","namespace":"ns","room":"r1","user":"u1","time":"20220301-100000.100","message":"peer_server_started"}
+{"level":"info","context":"peer","namespace":"ns","room":"r1","user":"u2","time":"20220301-100001.000","message":"peer_server_started"}
+{"level":"info","context":"room","namespace":"ns","room":"r1","time":"20220301-100002.000","message":"room_started"}
+not a JSON line
+{"level":"debug","context":"track","namespace":"ns","room":"r1","user":"u1","time":"20220301-100005.000","value":100,"unit":"kbit/s","message":"video_in_bitrate_estimated"}
+{"level":"debug","context":"track","namespace":"ns","room":"r1","user":"u1","time":"20220301-100008.000","value":200,"unit":"kbit/s","message":"video_in_bitrate_estimated"}
+{"level":"trace","context":"track","namespace":"ns","room":"r1","user":"u1","time":"20220301-100008.000","lost":1,"count":100,"message":"video_in_report"}
+{"level":"debug","context":"signaling","namespace":"ns","room":"r1","user":"u2","time":"20220301-100008.000","source":"client","value":"30","message":"client_video_fps_updated"}
+{"level":"info","context":"track","namespace":"ns","room":"r1","user":"u2","time":"20220301-100009.000","cause":"","message":"pli_sent"}
+{"level":"info","context":"peer","namespace":"ns","room":"r1","user":"u2","time":"20220301-100010.000","message":"peer_server_started"}
+{"level":"info","context":"room","namespace":"ns","room":"r1","time":"20220301-100032.000","message":"room_ended"}
+{"level":"info","context":"room","namespace":"ns","room":"r1","user":"u1","time":"20220301-110000.000","message":"room_created"}`
+
+func TestBuild(t *testing.T) {
+	sessions, skipped, err := Build(strings.NewReader(logs), DefaultThresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("expected 1 skipped line, got %v", skipped)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("room reused: expected 2 sessions, got %v", len(sessions))
+	}
+
+	s := sessions[0]
+	if s.Duration().Seconds() != 30 {
+		t.Errorf("expected 30s duration, got %v", s.Duration())
+	}
+	u1, u2 := s.Users["u1"], s.Users["u2"]
+	if mean := u1.VideoInBitrate.Mean(); mean != 150 {
+		t.Errorf("expected 150 kbit/s mean, got %v", mean)
+	}
+	if rate, ok := u1.LossRate(); !ok || rate != 0.01 {
+		t.Errorf("expected 0.01 loss rate, got %v (available: %v)", rate, ok)
+	}
+	if _, ok := u2.LossRate(); ok || u2.PacketsCount != nil {
+		t.Errorf("expected unavailable u2 packets stats, got %v", u2.PacketsCount)
+	}
+	if len(u1.Flags) != 1 || u1.Flags[0] != "low_video_bitrate" {
+		t.Errorf("unexpected u1 flags: %v", u1.Flags)
+	}
+	if u2.Reconnections != 1 || u2.PLISent != 1 || u2.VideoFps.Mean() != 30 {
+		t.Errorf("unexpected u2 summary: %+v", u2)
+	}
+	if len(u2.Flags) != 1 || u2.Flags[0] != "reconnected" {
+		t.Errorf("unexpected u2 flags: %v", u2.Flags)
+	}
+
+	if len(sessions[1].Flags) != 1 || sessions[1].Flags[0] != "not_started" {
+		t.Errorf("unexpected second session flags: %v", sessions[1].Flags)
+	}
+}
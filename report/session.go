@@ -0,0 +1,249 @@
+package report
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timeFormat = "20060102-150405.000"
+
+// logEntry holds the zerolog properties used in reports (see README "Logs format")
+type logEntry struct {
+	Level     string          `json:"level"`
+	Time      string          `json:"time"`
+	Message   string          `json:"message"`
+	Namespace string          `json:"namespace"`
+	Room      string          `json:"room"`
+	User      string          `json:"user"`
+	Value     json.RawMessage `json:"value"`
+	Lost      uint64          `json:"lost"`
+	Count     uint64          `json:"count"`
+}
+
+// numeric value, whether encoded as a JSON number or string
+func (e logEntry) floatValue() (float64, bool) {
+	raw := strings.Trim(string(e.Value), `"`)
+	if len(raw) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	return v, err == nil
+}
+
+type Sample struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
+}
+
+type Series []Sample
+
+func (s Series) Mean() float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, sample := range s {
+		sum += sample.Value
+	}
+	return sum / float64(len(s))
+}
+
+func (s Series) Min() float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	min := s[0].Value
+	for _, sample := range s[1:] {
+		if sample.Value < min {
+			min = sample.Value
+		}
+	}
+	return min
+}
+
+// User summarizes one participant of a session, bitrates in kbit/s
+type User struct {
+	UserId                string   `json:"userId"`
+	JoinedCount           int      `json:"joinedCount"`
+	Reconnections         int      `json:"reconnections"`
+	AudioInBitrate        Series   `json:"audioInBitrate"`
+	VideoInBitrate        Series   `json:"videoInBitrate"`
+	AudioOutBitrate       Series   `json:"audioOutBitrate"`
+	VideoOutBitrate       Series   `json:"videoOutBitrate"`
+	VideoFps              Series   `json:"videoFps"`
+	PacketsCount          *uint64  `json:"packetsCount"` // nil if no *_in_report entry (logged at trace level)
+	PacketsLost           *uint64  `json:"packetsLost"`
+	LossThresholdExceeded int      `json:"lossThresholdExceeded"`
+	PLISent               int      `json:"pliSent"`
+	PLISkipped            int      `json:"pliSkipped"`
+	KeyFrameRequests      int      `json:"keyFrameRequests"`
+	FxChanges             int      `json:"fxChanges"`
+	TrackedFrames         int      `json:"trackedFrames"`
+	UntrackedFrames       int      `json:"untrackedFrames"`
+	Errors                int      `json:"errors"`
+	Flags                 []string `json:"flags"`
+}
+
+// LossRate is not available (ok is false) if logs don't contain packets counts
+func (u *User) LossRate() (rate float64, ok bool) {
+	if u.PacketsCount == nil || *u.PacketsCount == 0 {
+		return 0, false
+	}
+	return float64(*u.PacketsLost) / float64(*u.PacketsCount), true
+}
+
+func (u *User) UntrackedRate() float64 {
+	total := u.TrackedFrames + u.UntrackedFrames
+	if total == 0 {
+		return 0
+	}
+	return float64(u.UntrackedFrames) / float64(total)
+}
+
+// Session is one room lifetime, from room_created to its post-processing
+type Session struct {
+	Namespace string           `json:"namespace"`
+	Room      string           `json:"room"`
+	CreatedAt string           `json:"createdAt"`
+	StartedAt string           `json:"startedAt"`
+	EndedAt   string           `json:"endedAt"`
+	Users     map[string]*User `json:"users"`
+	Flags     []string         `json:"flags"`
+}
+
+func newSession(e logEntry) *Session {
+	return &Session{
+		Namespace: e.Namespace,
+		Room:      e.Room,
+		CreatedAt: e.Time,
+		Users:     make(map[string]*User),
+		Flags:     []string{},
+	}
+}
+
+func (s *Session) user(userId string) *User {
+	u, ok := s.Users[userId]
+	if !ok {
+		u = &User{UserId: userId, Flags: []string{}}
+		s.Users[userId] = u
+	}
+	return u
+}
+
+func (s *Session) SortedUserIds() (userIds []string) {
+	for userId := range s.Users {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return
+}
+
+func (s *Session) Duration() time.Duration {
+	start, err := time.Parse(timeFormat, s.StartedAt)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse(timeFormat, s.EndedAt)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func (s *Session) add(e logEntry) {
+	switch e.Message {
+	case "room_started":
+		s.StartedAt = e.Time
+		return
+	case "room_ended":
+		s.EndedAt = e.Time
+		return
+	}
+
+	if len(e.User) == 0 {
+		return
+	}
+	u := s.user(e.User)
+	if e.Level == "error" {
+		u.Errors++
+	}
+
+	switch e.Message {
+	case "peer_server_started":
+		u.JoinedCount++
+		if u.JoinedCount > 1 {
+			u.Reconnections++
+		}
+	case "audio_in_bitrate_estimated", "video_in_bitrate_estimated", "audio_out_bitrate_estimated", "video_out_bitrate_estimated":
+		if v, ok := e.floatValue(); ok {
+			sample := Sample{e.Time, v}
+			switch e.Message {
+			case "audio_in_bitrate_estimated":
+				u.AudioInBitrate = append(u.AudioInBitrate, sample)
+			case "video_in_bitrate_estimated":
+				u.VideoInBitrate = append(u.VideoInBitrate, sample)
+			case "audio_out_bitrate_estimated":
+				u.AudioOutBitrate = append(u.AudioOutBitrate, sample)
+			case "video_out_bitrate_estimated":
+				u.VideoOutBitrate = append(u.VideoOutBitrate, sample)
+			}
+		}
+	case "client_video_fps_updated":
+		if v, ok := e.floatValue(); ok {
+			u.VideoFps = append(u.VideoFps, Sample{e.Time, v})
+		}
+	case "audio_in_report", "video_in_report":
+		if u.PacketsCount == nil {
+			u.PacketsCount, u.PacketsLost = new(uint64), new(uint64)
+		}
+		*u.PacketsCount += e.Count
+		*u.PacketsLost += e.Lost
+	case "loss_threshold_exceeded":
+		u.LossThresholdExceeded++
+	case "pli_sent":
+		u.PLISent++
+	case "pli_skipped":
+		u.PLISkipped++
+	case "encoder_keyframe_requested":
+		u.KeyFrameRequests++
+	case "client_fx_control":
+		u.FxChanges++
+	case "video_tracking":
+		if string(e.Value) == "true" {
+			u.TrackedFrames++
+		} else {
+			u.UntrackedFrames++
+		}
+	}
+}
+
+func sessionKey(namespace, room string) string {
+	return namespace + "#" + room
+}
+
+// parse builds sessions from log entries, a session being identified by its namespace, room
+// and creation (a room id may be reused once a previous room with the same id has been deleted,
+// which is why logs are attached to the last created room)
+func parse(entries []logEntry) []*Session {
+	sessions := []*Session{}
+	current := make(map[string]*Session)
+
+	for _, e := range entries {
+		if len(e.Room) == 0 {
+			continue
+		}
+		key := sessionKey(e.Namespace, e.Room)
+		s, ok := current[key]
+		if e.Message == "room_created" || !ok {
+			// logs may start while a room is running
+			s = newSession(e)
+			sessions = append(sessions, s)
+			current[key] = s
+		}
+		s.add(e)
+	}
+	return sessions
+}
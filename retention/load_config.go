@@ -0,0 +1,50 @@
+package retention
+
+import (
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultRules = "default"
+
+type rules struct {
+	MaxAge  int    `yaml:"maxAge"`  // days
+	MaxSize int64  `yaml:"maxSize"` // MB
+	Keep    string `yaml:"keep"`
+}
+
+type retentionConfig struct {
+	Interval   int              `yaml:"interval"` // minutes
+	DryRun     bool             `yaml:"dryRun"`
+	Namespaces map[string]rules `yaml:"namespaces"`
+}
+
+var config retentionConfig
+
+func init() {
+	f, err := helpers.Open("config/retention.yml")
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	err = decoder.Decode(&config)
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	for namespace, r := range config.Namespaces {
+		if r.Keep != "" && r.Keep != "wet" && r.Keep != "dry" {
+			log.Error().Str("context", "init").Str("namespace", namespace).Str("keep", r.Keep).Msg("retention_keep_invalid")
+		}
+	}
+}
+
+func rulesFor(namespace string) (r rules, found bool) {
+	if r, found = config.Namespaces[namespace]; found {
+		return
+	}
+	r, found = config.Namespaces[defaultRules]
+	return
+}
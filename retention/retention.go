@@ -0,0 +1,244 @@
+// Package retention removes recordings according to per-namespace rules (see config/retention.yml)
+package retention
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/recordings"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// files modified more recently are never removed (room may be finalizing or its files uploaded)
+	safetyDelay = 1 * time.Hour
+	day         = 24 * time.Hour
+	mb          = 1024 * 1024
+)
+
+// Removal describes a file that has been (or would be, in dry-run mode) removed
+type Removal struct {
+	Namespace string `json:"namespace"`
+	Session   string `json:"session"`
+	File      string `json:"file"` // relative to namespace folder
+	Size      int64  `json:"size"`
+	Reason    string `json:"reason"`  // encoder_log, keep_wet, keep_dry, max_age or max_size
+	Removed   bool   `json:"removed"` // false in dry-run mode or if removal failed
+	Error     string `json:"error,omitempty"`
+}
+
+// sessions with manifest are removed as a whole, files of unmanaged sessions one by one
+type unit struct {
+	session      *recordings.Session
+	files        []recordings.File
+	lastModified time.Time
+}
+
+func newUnit(s *recordings.Session, files []recordings.File) unit {
+	u := unit{session: s, files: files}
+	for _, f := range files {
+		if f.ModifiedAt.After(u.lastModified) {
+			u.lastModified = f.ModifiedAt
+		}
+	}
+	return u
+}
+
+// returns true if unit contains the recording matching f (same prefix, media kind and segment) with
+// the other processing (wet for dry, dry for wet)
+func hasCounterpart(u unit, f recordings.File, from, to string) bool {
+	kind := strings.TrimSuffix(f.Kind, from) + to
+	for _, other := range u.files {
+		if other.Kind == kind && other.Time == f.Time && other.Room == f.Room && other.User == f.User && other.Connection == f.Connection && other.Segment == f.Segment {
+			return true
+		}
+	}
+	return false
+}
+
+// plan lists files to be removed from namespace sessions, given rules
+func plan(sessions []*recordings.Session, r rules, now time.Time, active func(*recordings.Session) bool) []Removal {
+	removals := []Removal{}
+	planned := make(map[string]bool)
+	add := func(u unit, f recordings.File, reason string) {
+		if planned[f.Name] {
+			return
+		}
+		planned[f.Name] = true
+		removals = append(removals, Removal{
+			Namespace: u.session.Namespace,
+			Session:   u.session.Id,
+			File:      f.Name,
+			Size:      f.Size,
+			Reason:    reason,
+		})
+	}
+
+	var total int64
+	units := []unit{}
+	for _, s := range sessions {
+		total += s.Size
+		if active(s) {
+			continue
+		}
+		candidates := []unit{}
+		if s.Unmanaged() {
+			for _, f := range s.Files {
+				candidates = append(candidates, newUnit(s, []recordings.File{f}))
+			}
+		} else {
+			candidates = append(candidates, newUnit(s, s.Files))
+		}
+		for _, u := range candidates {
+			if now.Sub(u.lastModified) > safetyDelay {
+				units = append(units, u)
+			}
+		}
+	}
+	// oldest first
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].lastModified.Before(units[j].lastModified)
+	})
+
+	for _, u := range units {
+		for _, f := range u.files {
+			// x264 multipass cache files are useless once recordings are done
+			if f.Kind == "encoder_log" {
+				add(u, f, "encoder_log")
+			}
+			// only copy of a recording (for instance dry without fx) is kept
+			if r.Keep == "wet" && strings.HasSuffix(f.Kind, "dry") && hasCounterpart(u, f, "dry", "wet") {
+				add(u, f, "keep_wet")
+			}
+			if r.Keep == "dry" && strings.HasSuffix(f.Kind, "wet") && hasCounterpart(u, f, "wet", "dry") {
+				add(u, f, "keep_dry")
+			}
+		}
+	}
+	if r.MaxAge > 0 {
+		for _, u := range units {
+			if now.Sub(u.lastModified) > time.Duration(r.MaxAge)*day {
+				for _, f := range u.files {
+					add(u, f, "max_age")
+				}
+			}
+		}
+	}
+	if r.MaxSize > 0 {
+		for _, removal := range removals {
+			total -= removal.Size
+		}
+		for _, u := range units {
+			if total <= r.MaxSize*mb {
+				break
+			}
+			for _, f := range u.files {
+				if !planned[f.Name] {
+					add(u, f, "max_size")
+					total -= f.Size
+				}
+			}
+		}
+	}
+	return removals
+}
+
+func remove(removal *Removal, dryRun bool) {
+	logger := log.With().
+		Str("context", "retention").
+		Str("namespace", removal.Namespace).
+		Str("session", removal.Session).
+		Str("file", removal.File).
+		Int64("size", removal.Size).
+		Str("reason", removal.Reason).
+		Logger()
+
+	if dryRun {
+		logger.Info().Msg("file_removal_planned")
+		return
+	}
+	path, err := recordings.FilePath(removal.Namespace, removal.File)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		removal.Error = err.Error()
+		logger.Error().Err(err).Msg("file_removal_failed")
+		return
+	}
+	removal.Removed = true
+	logger.Info().Msg("file_removed")
+}
+
+// Apply enforces rules on all namespaces, only logging what would be removed if dryRun
+func Apply(dryRun bool) ([]Removal, error) {
+	namespaces, err := recordings.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	removals := []Removal{}
+	for _, namespace := range namespaces {
+		r, found := rulesFor(namespace)
+		if !found {
+			continue
+		}
+		sessions, err := recordings.Sessions(namespace)
+		if err != nil {
+			log.Error().Str("context", "retention").Str("namespace", namespace).Err(err).Msg("retention_failed")
+			continue
+		}
+		for _, removal := range plan(sessions, r, time.Now(), (*recordings.Session).Active) {
+			remove(&removal, dryRun)
+			removals = append(removals, removal)
+		}
+	}
+	var size int64
+	for _, removal := range removals {
+		size += removal.Size
+	}
+	log.Info().Str("context", "retention").Bool("dryRun", dryRun).Int("count", len(removals)).Int64("size", size).Msg("retention_applied")
+	return removals, nil
+}
+
+// Start runs the janitor periodically if an interval is set in config/retention.yml
+func Start() {
+	if config.Interval <= 0 {
+		return
+	}
+	log.Info().Str("context", "init").Int("interval", config.Interval).Bool("dryRun", config.DryRun).Msg("retention_enabled")
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Interval) * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := Apply(config.DryRun); err != nil {
+				log.Error().Str("context", "retention").Err(err).Msg("retention_failed")
+			}
+		}
+	}()
+}
+
+// Run is the retention command: applies rules once and writes removals as JSON to stdout
+func Run(args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", true, "only report files that would be removed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ducksoup retention [options]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	removals, err := Apply(*dryRun)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(removals)
+}
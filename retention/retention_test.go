@@ -0,0 +1,69 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creamlab/ducksoup/recordings"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-10 * day)
+	recent := now.Add(-2 * day)
+	sessions := []*recordings.Session{
+		{Id: "20220301-100002.000-n-ns-r-old", Namespace: "ns", Size: 40 * mb, Files: []recordings.File{
+			{Name: "old-dry.mkv", Kind: "dry", Size: 20 * mb, ModifiedAt: old, User: "u1"},
+			{Name: "old-wet.mkv", Kind: "wet", Size: 20 * mb, ModifiedAt: old, User: "u1"},
+		}},
+		{Id: "20220310-100002.000-n-ns-r-recent", Namespace: "ns", Size: 60 * mb, Files: []recordings.File{
+			// no matching wet recording: kept
+			{Name: "recent-audio-dry.ogg", Kind: "audio-dry", Size: 20 * mb, ModifiedAt: recent, User: "u1"},
+			{Name: "recent-video-dry.mkv", Kind: "video-dry", Size: 10 * mb, ModifiedAt: recent, User: "u1"},
+			{Name: "recent-video-wet.mkv", Kind: "video-wet", Size: 10 * mb, ModifiedAt: recent, User: "u1"},
+			// other user without fx: kept
+			{Name: "recent-u2-dry.mkv", Kind: "dry", Size: 20 * mb, ModifiedAt: recent, User: "u2"},
+		}},
+		{Id: "unmanaged-r-current", Namespace: "ns", Size: 20 * mb, Files: []recordings.File{
+			{Name: "logs/current.x264_pass.video.log", Kind: "encoder_log", Size: 1, ModifiedAt: recent},
+			{Name: "current-dry.mkv", Kind: "dry", Size: 20 * mb, ModifiedAt: now},
+		}},
+	}
+	inactive := func(*recordings.Session) bool { return false }
+
+	reasons := func(removals []Removal) map[string]string {
+		m := make(map[string]string)
+		for _, r := range removals {
+			m[r.File] = r.Reason
+		}
+		return m
+	}
+
+	got := reasons(plan(sessions, rules{Keep: "wet", MaxAge: 5}, now, inactive))
+	expected := map[string]string{
+		"logs/current.x264_pass.video.log": "encoder_log",
+		"old-dry.mkv":                      "keep_wet",
+		"recent-video-dry.mkv":             "keep_wet",
+		"old-wet.mkv":                      "max_age",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected removals: %v", got)
+	}
+	for file, reason := range expected {
+		if got[file] != reason {
+			t.Errorf("expected %v to be removed for %v, got %v", file, reason, got[file])
+		}
+	}
+
+	// oldest session is removed to get under 80MB, recent files are kept
+	got = reasons(plan(sessions, rules{MaxSize: 80}, now, inactive))
+	if len(got) != 3 || got["old-dry.mkv"] != "max_size" || got["old-wet.mkv"] != "max_size" {
+		t.Errorf("unexpected removals: %v", got)
+	}
+
+	// active sessions are kept
+	got = reasons(plan(sessions, rules{MaxAge: 1}, now, func(*recordings.Session) bool { return true }))
+	if len(got) != 0 {
+		t.Errorf("unexpected removals: %v", got)
+	}
+}
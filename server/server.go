@@ -10,11 +10,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/creamlab/ducksoup/events"
 	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/recordings"
 	"github.com/creamlab/ducksoup/sfu"
 	"github.com/creamlab/ducksoup/stats"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
@@ -79,6 +82,23 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// debug websocket streaming events, filtered by the optional "kinds" comma-separated parameter
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	unsafeConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error().Str("context", "server").Err(err).Msg("can't upgrade websocket")
+		return
+	}
+
+	var kinds []events.Kind
+	if param := r.FormValue("kinds"); len(param) > 0 {
+		for _, kind := range strings.Split(param, ",") {
+			kinds = append(kinds, events.Kind(kind))
+		}
+	}
+	stats.RunEventsServer(unsafeConn, kinds) // blocking
+}
+
 func basicAuthWith(refLogin, refPassword string) mux.MiddlewareFunc {
 	// source https://www.alexedwards.net/blog/basic-authentication-in-go
 	return func(next http.Handler) http.Handler {
@@ -131,11 +151,20 @@ func ListenAndServe() {
 	testRouter.PathPrefix("/room/").Handler(http.StripPrefix(webPrefix+"/test/room/", http.FileServer(http.Dir("./front/static/pages/test/room/"))))
 	testRouter.PathPrefix("/play/").Handler(http.StripPrefix(webPrefix+"/test/play/", http.FileServer(http.Dir("./front/static/pages/test/play/"))))
 
+	// recordings API with its own credentials
+	if recordings.Enabled() {
+		recordingsRouter := router.PathPrefix(webPrefix + "/recordings").Subrouter()
+		recordings.Route(recordingsRouter)
+	}
+
 	// stats pages with basic auth
 	if config.GenerateStats {
 		statsRouter := router.PathPrefix(webPrefix + "/stats").Subrouter()
 		statsRouter.Use(basicAuthWith(statsLogin, statsPassword))
+		statsRouter.HandleFunc("/events", eventsHandler)
 		statsRouter.PathPrefix("/").Handler(http.StripPrefix(webPrefix+"/stats/", http.FileServer(http.Dir("./front/static/pages/stats/"))))
+		// prometheus metrics, same credentials
+		router.Handle(webPrefix+"/metrics", basicAuthWith(statsLogin, statsPassword)(promhttp.Handler()))
 	}
 
 	// port
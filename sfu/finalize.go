@@ -0,0 +1,187 @@
+package sfu
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/gst"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// max duration to wait for recordings to be finalized
+	pipelinesTimeout = 30 * time.Second
+)
+
+// blocks until started pipelines have been deleted (meaning their recordings are finalized) or timeout
+func (r *room) waitForPipelines() (done bool) {
+	r.RLock()
+	pipelines := r.pipelines
+	r.RUnlock()
+
+	timeout := time.After(pipelinesTimeout)
+	for _, p := range pipelines {
+		if !p.IsStarted() {
+			continue
+		}
+		select {
+		case <-p.Done():
+		case <-timeout:
+			r.logger.Error().Msg("pipelines_wait_timed_out")
+			return false
+		}
+	}
+	return true
+}
+
+func (r *room) runCompositeRecording() {
+	r.RLock()
+	pipelines := r.pipelines
+	r.RUnlock()
+
+	p := gst.CreateCompositePipeline(r.join, r.roomFilePrefix(), pipelines, r.logWriter)
+	if p == nil {
+		r.logger.Info().Msg("room_composite_skipped")
+		return
+	}
+	r.logger.Info().Msg("room_composite_started")
+	<-p.Done()
+
+	// only listed (manifest, files_ready event) once successfully written
+	path := gst.CompositeFile(r.namespace, r.roomFilePrefix())
+	if pipelineErrors := p.Errors(); len(pipelineErrors) > 0 {
+		r.logger.Error().Str("error", pipelineErrors[0].Message).Msg("room_composite_failed")
+		return
+	}
+	if _, err := os.Stat("data/" + path); err != nil {
+		r.logger.Error().Err(err).Msg("room_composite_failed")
+		return
+	}
+	r.Lock()
+	r.filesIndex[roomFilesKey] = []string{path}
+	r.Unlock()
+	r.logger.Info().Str("file", path).Msg("room_composite_ended")
+}
+
+// replaces, in filesIndex, segmented recordings by their concatenated version, or by their segments
+// if they could not be concatenated
+func (r *room) concatenateSegments(done bool) {
+	r.RLock()
+	pipelines := r.pipelines
+	r.RUnlock()
+
+	for _, p := range pipelines {
+		if !p.IsStarted() || !p.IsSegmented() {
+			continue
+		}
+		replacements := p.ConcatenateSegments(done)
+
+		r.Lock()
+		for userId, paths := range r.filesIndex {
+			replaced := []string{}
+			for _, path := range paths {
+				if actual, ok := replacements[path]; ok {
+					replaced = append(replaced, actual...)
+				} else {
+					replaced = append(replaced, path)
+				}
+			}
+			r.filesIndex[userId] = replaced
+		}
+		r.Unlock()
+	}
+}
+
+// recordings of pipelines that are not done (waiting for them has timed out) may still be written
+func isPending(pipelines []*gst.Pipeline, path string) bool {
+	for _, p := range pipelines {
+		if !p.IsStarted() || !p.Writes(path) {
+			continue
+		}
+		select {
+		case <-p.Done():
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// replaces recordings by their encrypted version if encryption is enabled for namespace. Pending
+// recordings are left as is, to be encrypted later by the repair command
+func (r *room) encryptFiles() {
+	if !encryption.Enabled(r.namespace) {
+		return
+	}
+
+	// copied not to hold lock while encrypting
+	r.RLock()
+	pipelines := r.pipelines
+	filesIndex := make(map[string][]string)
+	for userId, paths := range r.filesIndex {
+		filesIndex[userId] = append([]string{}, paths...)
+	}
+	r.RUnlock()
+
+	for _, paths := range filesIndex {
+		for i, path := range paths {
+			if isPending(pipelines, path) {
+				r.logger.Error().Str("file", path).Msg("encryption_skipped")
+				continue
+			}
+			encryptedPath, err := encryption.EncryptFile(r.namespace, path)
+			if err != nil {
+				r.logger.Error().Str("file", path).Err(err).Msg("file_encryption_failed")
+			}
+			paths[i] = encryptedPath
+		}
+	}
+
+	r.Lock()
+	r.filesIndex = filesIndex
+	r.Unlock()
+	r.logger.Info().Msg("files_encrypted")
+}
+
+// post-processing once room has ended
+func (r *room) finalize() {
+	defer func() {
+		r.setFinalizing(false)
+		close(r.finalizedCh)
+	}()
+	done := r.waitForPipelines()
+
+	r.concatenateSegments(done)
+	if r.roomRecording {
+		r.runCompositeRecording()
+	}
+	r.encryptFiles()
+	data := events.FilesData{
+		Session:  r.sessionId(),
+		Files:    r.files(),
+		Complete: done,
+	}
+	m := r.newManifest()
+	if r.writeManifest(m) {
+		data.Manifest = strings.TrimPrefix(r.manifestFile(), "data/")
+	}
+	r.logger.Info().Msg("room_finalized")
+	r.closeLog()
+	// announced once closed, so that the log file is complete when files are processed (for instance uploaded)
+	if r.logFile != nil {
+		path, err := encryption.EncryptFile(r.namespace, r.logPath())
+		if err != nil {
+			log.Error().Str("context", "room").Str("namespace", r.namespace).Str("room", r.id).Str("file", path).Err(err).Msg("file_encryption_failed")
+		}
+		// manifest expects the encrypted log if encryption is enabled
+		if len(data.Manifest) > 0 && path != m.LogFile {
+			m.LogFile = path
+			r.writeManifest(m)
+		}
+		data.Log = path
+	}
+	events.Publish(events.FilesReady, r.namespace, r.id, "", data)
+}
@@ -9,8 +9,10 @@ import (
 )
 
 type sfuConfig struct {
-	Audio sfuStream
-	Video sfuStream
+	Audio    sfuStream
+	Video    sfuStream
+	Shutdown sfuShutdown
+	Watchdog sfuWatchdog
 }
 
 type sfuStream struct {
@@ -19,6 +21,17 @@ type sfuStream struct {
 	MaxBitrate     uint64 `yaml:"maxBitrate"`
 }
 
+type sfuShutdown struct {
+	WaitForRooms    bool `yaml:"waitForRooms"`
+	RoomsTimeout    int  `yaml:"roomsTimeout"`
+	FinalizeTimeout int  `yaml:"finalizeTimeout"`
+	EventsTimeout   int  `yaml:"eventsTimeout"`
+}
+
+type sfuWatchdog struct {
+	Action string // none, notify or fallback
+}
+
 var config sfuConfig
 
 func init() {
@@ -0,0 +1,168 @@
+package sfu
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/creamlab/ducksoup/encryption"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/creamlab/ducksoup/types"
+)
+
+// manifest data is collected while room is running, then written when room has ended
+// (see finalize) with checksums of the then finalized recordings
+
+func (r *room) newConnectionRecord(join types.JoinPayload, filePrefix string) *types.ManifestConnection {
+	r.Lock()
+	defer r.Unlock()
+
+	record := &types.ManifestConnection{
+		Join:        join,
+		FilePrefix:  filePrefix,
+		ConnectedAt: time.Now(),
+		Tracks:      []types.ManifestTrack{},
+		FxEvents:    []types.ManifestFxEvent{},
+	}
+	r.connectionRecords = append(r.connectionRecords, record)
+	return record
+}
+
+func (r *room) addTrackRecord(ps *peerServer, track types.ManifestTrack) {
+	r.Lock()
+	defer r.Unlock()
+
+	ps.record.Tracks = append(ps.record.Tracks, track)
+}
+
+func (r *room) setRecordingStartedAt(ps *peerServer, startedAt time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	ps.record.RecordingStartedAt = startedAt
+}
+
+func (r *room) addFxEventRecord(ps *peerServer, name, property, value string, duration int) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	sinceStart := int64(-1)
+	if !r.startedAt.IsZero() {
+		sinceStart = now.Sub(r.startedAt).Milliseconds()
+	}
+	ps.record.FxEvents = append(ps.record.FxEvents, types.ManifestFxEvent{
+		Time:       now,
+		SinceStart: sinceStart,
+		Name:       name,
+		Property:   property,
+		Value:      value,
+		Duration:   duration,
+	})
+}
+
+func (r *room) manifestFile() string {
+	return "data/" + r.namespace + "/" + r.roomFilePrefix() + "-manifest.json"
+}
+
+// manifest without file checksums
+func (r *room) manifestRecord() types.Manifest {
+	r.RLock()
+	defer r.RUnlock()
+
+	m := types.Manifest{
+		Namespace: r.namespace,
+		RoomId:    r.id,
+		Origin:    r.join.Origin,
+		Size:      r.size,
+		Duration:  r.duration,
+		CreatedAt: r.createdAt,
+		StartedAt: r.startedAt,
+		EndedAt:   r.endedAt,
+		Users:     []types.ManifestUser{},
+		Files:     []types.ManifestFile{},
+		Errors:    []types.ManifestError{},
+	}
+	if r.logFile != nil {
+		// encrypted once closed, after manifest is written (and updated if encryption fails)
+		m.LogFile = r.logPath()
+		if encryption.Enabled(r.namespace) {
+			m.LogFile += encryption.Suffix
+		}
+	}
+
+	userIds := []string{}
+	for userId := range r.joinedCountIndex {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	for _, userId := range userIds {
+		joinedCount := r.joinedCountIndex[userId]
+		user := types.ManifestUser{
+			UserId:            userId,
+			JoinedCount:       joinedCount,
+			ReconnectionCount: joinedCount - 1,
+			Connections:       []types.ManifestConnection{},
+		}
+		for _, record := range r.connectionRecords {
+			if record.Join.UserId == userId {
+				user.Connections = append(user.Connections, *record)
+			}
+		}
+		m.Users = append(m.Users, user)
+	}
+
+	for _, userId := range append(userIds, roomFilesKey) {
+		for _, path := range r.filesIndex[userId] {
+			file := types.ManifestFile{Path: path}
+			if userId != roomFilesKey {
+				file.UserId = userId
+			}
+			m.Files = append(m.Files, file)
+		}
+	}
+
+	for _, p := range r.pipelines {
+		m.Errors = append(m.Errors, p.Errors()...)
+	}
+
+	return m
+}
+
+func (r *room) newManifest() types.Manifest {
+	m := r.manifestRecord()
+
+	// may take a while for large recordings, room lock is not held meanwhile
+	for i, file := range m.Files {
+		size, checksum, err := helpers.FileChecksum("data/" + file.Path)
+		if err != nil {
+			r.logger.Error().Err(err).Str("file", file.Path).Msg("manifest_checksum_failed")
+			continue
+		}
+		m.Files[i].Size = size
+		m.Files[i].SHA256 = checksum
+	}
+	return m
+}
+
+func (r *room) writeManifest(m types.Manifest) (ok bool) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		r.logger.Error().Err(err).Msg("manifest_write_failed")
+		return false
+	}
+
+	// write then rename so that a manifest is either missing or complete
+	path := r.manifestFile()
+	if err = os.WriteFile(path+".tmp", data, 0664); err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		r.logger.Error().Err(err).Msg("manifest_write_failed")
+		return false
+	}
+	r.logger.Info().Str("file", path).Msg("manifest_written")
+	return true
+}
@@ -0,0 +1,123 @@
+package sfu
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	roomStates = []string{"waiting", "running", "ending", "ended"}
+	// counters updated by sfu components
+	pliSentCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ducksoup_pli_sent_total",
+		Help: "Picture Loss Indications sent to peers",
+	})
+	pliSkippedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ducksoup_pli_skipped_total",
+		Help: "Picture Loss Indications skipped due to throttling",
+	})
+	keyFrameRequestCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ducksoup_encoder_keyframe_requests_total",
+		Help: "Keyframes requested to GStreamer encoders",
+	})
+	fractionLostHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ducksoup_rtcp_fraction_lost",
+		Help:    "Fraction of packets lost as reported by peers RTCP receiver reports",
+		Buckets: []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+	}, []string{"kind"})
+	wsErrorCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ducksoup_websocket_errors_total",
+		Help: "Websocket read and write errors",
+	})
+	// gauges computed on scrape
+	roomsDesc = prometheus.NewDesc(
+		"ducksoup_rooms",
+		"Rooms by state",
+		[]string{"state"}, nil,
+	)
+	peersDesc = prometheus.NewDesc(
+		"ducksoup_peers_connected",
+		"Connected peers",
+		nil, nil,
+	)
+	// values are summed per namespace and kind, to keep cardinality bounded
+	trackLabels      = []string{"namespace", "kind"}
+	inputBitrateDesc = prometheus.NewDesc(
+		"ducksoup_track_input_bitrate_bps",
+		"Estimated bitrate of incoming tracks",
+		trackLabels, nil,
+	)
+	outputBitrateDesc = prometheus.NewDesc(
+		"ducksoup_track_output_bitrate_bps",
+		"Estimated bitrate of outgoing (processed) tracks",
+		trackLabels, nil,
+	)
+	targetBitrateDesc = prometheus.NewDesc(
+		"ducksoup_track_target_bitrate_bps",
+		"Target bitrate of outgoing tracks encoders",
+		trackLabels, nil,
+	)
+)
+
+// roomStoreCollector implements prometheus.Collector, values are read from rooms on scrape
+type roomStoreCollector struct{}
+
+func init() {
+	prometheus.MustRegister(roomStoreCollector{})
+}
+
+func (c roomStoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+	ch <- peersDesc
+	ch <- inputBitrateDesc
+	ch <- outputBitrateDesc
+	ch <- targetBitrateDesc
+}
+
+func (c roomStoreCollector) Collect(ch chan<- prometheus.Metric) {
+	// copy rooms so that the store lock is not held while locking rooms
+	// (room deletion locks the store while holding the room lock)
+	roomStoreSingleton.Lock()
+	rooms := make([]*room, 0, len(roomStoreSingleton.index))
+	for _, r := range roomStoreSingleton.index {
+		rooms = append(rooms, r)
+	}
+	roomStoreSingleton.Unlock()
+
+	type trackKey struct{ namespace, kind string }
+	type trackValues struct{ input, output, target uint64 }
+	roomCount := make(map[string]int)
+	peerCount := 0
+	tracks := make(map[trackKey]*trackValues)
+	for _, r := range rooms {
+		r.RLock()
+		roomCount[r.state()]++
+		peerCount += r.connectedUserCount()
+		r.RUnlock()
+
+		r.mixer.RLock()
+		for _, s := range r.mixer.sliceIndex {
+			key := trackKey{r.namespace, s.kind}
+			if tracks[key] == nil {
+				tracks[key] = &trackValues{}
+			}
+			s.Lock()
+			tracks[key].input += s.inputBitrate
+			tracks[key].output += s.outputBitrate
+			tracks[key].target += s.optimalBitrate
+			s.Unlock()
+		}
+		r.mixer.RUnlock()
+	}
+
+	for key, values := range tracks {
+		ch <- prometheus.MustNewConstMetric(inputBitrateDesc, prometheus.GaugeValue, float64(values.input), key.namespace, key.kind)
+		ch <- prometheus.MustNewConstMetric(outputBitrateDesc, prometheus.GaugeValue, float64(values.output), key.namespace, key.kind)
+		ch <- prometheus.MustNewConstMetric(targetBitrateDesc, prometheus.GaugeValue, float64(values.target), key.namespace, key.kind)
+	}
+
+	for _, state := range roomStates {
+		ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(roomCount[state]), state)
+	}
+	ch <- prometheus.MustNewConstMetric(peersDesc, prometheus.GaugeValue, float64(peerCount))
+}
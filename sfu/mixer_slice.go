@@ -3,12 +3,15 @@ package sfu
 import (
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/creamlab/ducksoup/gst"
 	"github.com/creamlab/ducksoup/helpers"
 	"github.com/creamlab/ducksoup/sequencing"
+	"github.com/creamlab/ducksoup/types"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog"
@@ -46,12 +49,33 @@ type mixerSlice struct {
 	outputBits    uint64
 	inputBitrate  uint64
 	outputBitrate uint64
+	// sync
+	firstPacketAt     time.Time
+	firstRTPTimestamp uint32
+	senderReports     []types.ManifestSenderReport
 	// status
 	endCh chan struct{} // stop processing when track is removed
+	// incoming packets are forwarded as is while pipeline is stalled, see config/sfu.yml
+	forwardingDry bool
 }
 
 // helpers
 
+// NTP timestamps are 32.32 fixed point numbers counting seconds since 1900
+func ntpToTime(ntp uint64) time.Time {
+	const ntpEpochOffset = 2208988800 // seconds between 1900 and 1970
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanos := int64((ntp & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+func timeToNTP(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+	seconds := uint64(t.Unix()+ntpEpochOffset) << 32
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	return seconds | fraction
+}
+
 func minUint64(v []uint64) (min uint64) {
 	if len(v) > 0 {
 		min = v[0]
@@ -94,7 +118,7 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 		// webrtc
 		input:    remoteTrack,
 		output:   localTrack,
-		receiver: receiver, // TODO read RTCP?
+		receiver: receiver,
 		// processing
 		pipeline:          ps.pipeline,
 		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
@@ -142,6 +166,74 @@ func (s *mixerSlice) addSender(sender *webrtc.RTPSender, toUserId string) {
 	}
 }
 
+// keyframes are requested to the wet encoder when the output track is reencoded, otherwise to the remote peer
+func (s *mixerSlice) requestKeyFrame(cause string) {
+	if s.pipeline.IsVideoReencoded() {
+		s.pipeline.RequestKeyFrame()
+		keyFrameRequestCounter.Inc()
+		s.logInfo().Str("cause", cause).Msg("encoder_keyframe_requested")
+	} else {
+		s.fromPs.pc.throttledPLIRequest(cause)
+	}
+}
+
+// first packet pushed to pipeline maps wall-clock and RTP time for this track
+func (s *mixerSlice) recordFirstPacket(buf []byte) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(buf); err != nil {
+		return
+	}
+	s.Lock()
+	s.firstPacketAt = time.Now()
+	s.firstRTPTimestamp = packet.Timestamp
+	s.Unlock()
+}
+
+func (s *mixerSlice) recordSenderReport(sr *rtcp.SenderReport) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.senderReports = append(s.senderReports, types.ManifestSenderReport{
+		ReceivedAt:   time.Now(),
+		NTPTime:      sr.NTPTime,
+		NTPWallClock: ntpToTime(sr.NTPTime),
+		RTPTimestamp: sr.RTPTime,
+	})
+}
+
+// capture time (sender clock) of the first packet, given by the first sender report, or arrival time
+// if no sender report has been received
+func (s *mixerSlice) firstCaptureAt(clockRate uint32) time.Time {
+	if len(s.senderReports) == 0 || clockRate == 0 {
+		return s.firstPacketAt
+	}
+	sr := s.senderReports[0]
+	// signed difference handles RTP timestamp wrap-around
+	delta := int64(int32(s.firstRTPTimestamp - sr.RTPTimestamp))
+	return sr.NTPWallClock.Add(time.Duration(delta) * time.Second / time.Duration(clockRate))
+}
+
+// track sync data is added to the manifest when slice ends, and used to align room composites
+func (s *mixerSlice) addTrackRecord() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.firstPacketAt.IsZero() {
+		return
+	}
+	codec := s.input.Codec()
+	s.pipeline.SetMediaStart(s.firstCaptureAt(codec.ClockRate))
+	s.r.addTrackRecord(s.fromPs, types.ManifestTrack{
+		Kind:              s.kind,
+		Codec:             codec.MimeType,
+		ClockRate:         codec.ClockRate,
+		SSRC:              uint32(s.input.SSRC()),
+		FirstPacketAt:     s.firstPacketAt,
+		FirstRTPTimestamp: s.firstRTPTimestamp,
+		SenderReports:     s.senderReports,
+	})
+}
+
 func (l *mixerSlice) scanInput(buf []byte, n int) {
 	packet := &rtp.Packet{}
 	packet.Unmarshal(buf)
@@ -153,9 +245,66 @@ func (l *mixerSlice) scanInput(buf []byte, n int) {
 	l.Unlock()
 }
 
+func (s *mixerSlice) isForwardingDry() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.forwardingDry
+}
+
+// PipelineStalled is called by the pipeline watchdog
+func (s *mixerSlice) PipelineStalled(stalled bool) {
+	action := config.Watchdog.Action
+	if action != "notify" && action != "fallback" {
+		return
+	}
+	kind := "pipeline_recovered"
+	if stalled {
+		kind = "pipeline_stalled"
+	}
+	s.fromPs.ws.sendWithPayload(kind, s.kind)
+
+	if action == "fallback" {
+		s.Lock()
+		s.forwardingDry = stalled
+		s.Unlock()
+		if stalled {
+			s.logError().Str("track", s.ID()).Msg("dry_forwarding_started")
+			if s.kind == "video" {
+				s.fromPs.pc.throttledPLIRequest("dry_forwarding")
+			}
+		} else {
+			s.logInfo().Str("track", s.ID()).Msg("dry_forwarding_stopped")
+			if s.kind == "video" {
+				s.requestKeyFrame("pipeline_recovered")
+			}
+		}
+	}
+}
+
+// incoming packet sent to other peers instead of processed one (header extensions are negotiated
+// per peer connection and are not kept)
+func (s *mixerSlice) forwardDry(buf []byte) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(buf); err != nil {
+		return
+	}
+	packet.Header.Extension = false
+	packet.Header.Extensions = nil
+	s.writeRTP(packet)
+}
+
+// pipeline output (dropped while forwarding incoming packets)
 func (s *mixerSlice) Write(buf []byte) (err error) {
+	if s.isForwardingDry() {
+		return
+	}
 	packet := &rtp.Packet{}
 	packet.Unmarshal(buf)
+	return s.writeRTP(packet)
+}
+
+func (s *mixerSlice) writeRTP(packet *rtp.Packet) (err error) {
 	err = s.output.WriteRTP(packet)
 
 	if err == nil {
@@ -184,17 +333,20 @@ func (s *mixerSlice) loop() {
 	outputFiles := pipeline.BindTrack(s.kind, s)
 	if outputFiles != nil {
 		room.addFiles(userId, outputFiles)
+		room.setRecordingStartedAt(s.fromPs, pipeline.StartedAt())
 	}
 	go s.runTickers()
-	// go s.runReceiverListener()
+	go s.runReceiverListener()
 
 	defer func() {
 		msg := fmt.Sprintf("%s_track_stopped", s.kind)
 		s.logInfo().Str("track", s.ID()).Msg(msg)
+		s.addTrackRecord()
 		s.stop()
 	}()
 
 	buf := make([]byte, defaultMTU)
+	firstPacket := true
 	for {
 		select {
 		case <-room.endCh:
@@ -209,6 +361,13 @@ func (s *mixerSlice) loop() {
 				return
 			}
 			s.pipeline.PushRTP(s.kind, buf[:i])
+			if s.isForwardingDry() {
+				s.forwardDry(buf[:i])
+			}
+			if firstPacket {
+				s.recordFirstPacket(buf[:i])
+				firstPacket = false
+			}
 			// for stats
 			go s.scanInput(buf, i)
 		}
@@ -269,45 +428,48 @@ func (s *mixerSlice) runTickers() {
 			outputMsg := fmt.Sprintf("%s_out_bitrate_estimated", s.output.Kind().String())
 			s.logDebug().Uint64("value", displayInputBitrateKbs).Str("unit", "kbit/s").Msg(inputMsg)
 			s.logDebug().Uint64("value", displayOutputBitrateKbs).Str("unit", "kbit/s").Msg(outputMsg)
+			// reception stats per recipient
+			s.Lock()
+			for _, sc := range s.senderControllerIndex {
+				sc.logStats()
+			}
+			s.Unlock()
 		}
 	}()
 }
 
-// func (s *mixerSlice) runReceiverListener() {
-// 	buf := make([]byte, defaultMTU)
-
-// 	for {
-// 		select {
-// 		case <-s.endCh:
-// 			return
-// 		default:
-// 			i, _, err := s.receiver.Read(buf)
-// 			if err != nil {
-// 				if err != io.EOF && err != io.ErrClosedPipe {
-// 					s.logError().Err(err).Msg("can't read RTCP packet")
-// 				}
-// 				return
-// 			}
-// 			// TODO: send to rtpjitterbuffer sink_rtcp
-// 			//s.pipeline.PushRTCP(s.kind, buf[:i])
-
-// 			packets, err := rtcp.Unmarshal(buf[:i])
-// 			if err != nil {
-// 				s.logError().Err(err).Msg("can't unmarshal RTCP packet")
-// 				continue
-// 			}
-
-// 			for _, packet := range packets {
-// 				switch rtcpPacket := packet.(type) {
-// 				case *rtcp.SourceDescription:
-// 				// case *rtcp.SenderReport:
-// 				// 	log.Println(rtcpPacket)
-// 				// case *rtcp.ReceiverEstimatedMaximumBitrate:
-// 				// 	log.Println(rtcpPacket)
-// 				default:
-// 					//s.logInfo().Msgf("%T %+v", rtcpPacket, rtcpPacket)
-// 				}
-// 			}
-// 		}
-// 	}
-// }
+func (s *mixerSlice) runReceiverListener() {
+	buf := make([]byte, defaultMTU)
+
+	for {
+		select {
+		case <-s.endCh:
+			return
+		default:
+			i, _, err := s.receiver.Read(buf)
+			if err != nil {
+				if err != io.EOF && err != io.ErrClosedPipe {
+					s.logError().Err(err).Msg("can't read RTCP packet")
+				}
+				return
+			}
+			// used by rtpjitterbuffer
+			s.pipeline.PushRTCP(s.kind, buf[:i])
+
+			packets, err := rtcp.Unmarshal(buf[:i])
+			if err != nil {
+				s.logError().Err(err).Msg("can't unmarshal RTCP packet")
+				continue
+			}
+
+			for _, packet := range packets {
+				switch rtcpPacket := packet.(type) {
+				case *rtcp.SenderReport:
+					if rtcpPacket.SSRC == uint32(s.input.SSRC()) {
+						s.recordSenderReport(rtcpPacket)
+					}
+				}
+			}
+		}
+	}
+}
@@ -177,6 +177,59 @@ func (pc *peerConn) connectPeerServer(ps *peerServer) {
 	// }()
 }
 
+// stats available from pion (RTP stream stats are computed from RTCP, see senderController)
+type peerConnStats struct {
+	BytesSent           uint64
+	BytesReceived       uint64
+	CandidatePairState  string
+	LocalCandidateType  string
+	RemoteCandidateType string
+	Protocol            string
+}
+
+func (pc *peerConn) stats() (s peerConnStats) {
+	report := pc.GetStats()
+	var pair *webrtc.ICECandidatePairStats
+	for _, stat := range report {
+		switch v := stat.(type) {
+		case webrtc.TransportStats:
+			s.BytesSent = v.BytesSent
+			s.BytesReceived = v.BytesReceived
+		case webrtc.ICECandidatePairStats:
+			if v.Nominated {
+				pair = &v
+			}
+		}
+	}
+	if pair != nil {
+		s.CandidatePairState = string(pair.State)
+		if local, ok := report[pair.LocalCandidateID].(webrtc.ICECandidateStats); ok {
+			s.LocalCandidateType = local.CandidateType.String()
+			s.Protocol = local.Protocol
+		}
+		if remote, ok := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats); ok {
+			s.RemoteCandidateType = remote.CandidateType.String()
+		}
+	}
+	return
+}
+
+func (pc *peerConn) inspect() interface{} {
+	return pc.stats()
+}
+
+func (pc *peerConn) logStats() {
+	s := pc.stats()
+	pc.logDebug().
+		Uint64("bytesSent", s.BytesSent).
+		Uint64("bytesReceived", s.BytesReceived).
+		Str("candidatePairState", s.CandidatePairState).
+		Str("localCandidateType", s.LocalCandidateType).
+		Str("remoteCandidateType", s.RemoteCandidateType).
+		Str("protocol", s.Protocol).
+		Msg("peer_connection_stats")
+}
+
 func (pc *peerConn) writePLI(track *webrtc.TrackRemote, cause string) (err error) {
 	err = pc.WriteRTCP([]rtcp.Packet{
 		&rtcp.PictureLossIndication{
@@ -189,6 +242,7 @@ func (pc *peerConn) writePLI(track *webrtc.TrackRemote, cause string) (err error
 		pc.Lock()
 		pc.lastPLI = time.Now()
 		pc.Unlock()
+		pliSentCounter.Inc()
 		pc.logInfo().Str("context", "track").Str("cause", cause).Msg("pli_sent")
 	}
 	return
@@ -216,6 +270,7 @@ func (pc *peerConn) throttledPLIRequest(cause string) {
 			durationSinceLastPLI := time.Since(pc.lastPLI)
 			if durationSinceLastPLI < pc.pliMinInterval {
 				// throttle: don't send too many PLIs
+				pliSkippedCounter.Inc()
 				pc.logInfo().Str("context", "track").Str("cause", cause).Msg("pli_skipped")
 			} else {
 				go pc.writePLI(track, cause)
@@ -29,8 +29,11 @@ type peerServer struct {
 	ws         *wsConn
 	audioSlice *mixerSlice
 	videoSlice *mixerSlice
-	closed     bool
-	closedCh   chan struct{}
+	// after a pipeline failure, tracks of this peer are not sent to others anymore
+	tracksRemoved bool
+	closed        bool
+	closedCh      chan struct{}
+	record        *types.ManifestConnection
 	// processing
 	pipeline          *gst.Pipeline
 	interpolatorIndex map[string]*sequencing.LinearInterpolator
@@ -42,7 +45,8 @@ func newPeerServer(
 	pc *peerConn,
 	ws *wsConn) *peerServer {
 
-	pipeline := gst.CreatePipeline(join, r.filePrefixWithCount(join))
+	filePrefix := r.filePrefixWithCount(join)
+	pipeline := gst.CreatePipeline(join, filePrefix, r.logWriter)
 
 	ps := &peerServer{
 		userId:            join.UserId,
@@ -54,6 +58,7 @@ func newPeerServer(
 		ws:                ws,
 		closed:            false,
 		closedCh:          make(chan struct{}),
+		record:            r.newConnectionRecord(join, filePrefix),
 		pipeline:          pipeline,
 		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
 	}
@@ -77,12 +82,21 @@ func (ps *peerServer) logDebug() *zerolog.Event {
 	return ps.r.logger.Debug().Str("context", "signaling").Str("user", ps.userId)
 }
 
+// a slice set after a pipeline failure (see removeTracks) is removed from the mixer right away
 func (ps *peerServer) setMixerSlice(kind string, slice *mixerSlice) {
+	ps.Lock()
 	if kind == "audio" {
 		ps.audioSlice = slice
 	} else if kind == "video" {
 		ps.videoSlice = slice
 	}
+	tracksRemoved := ps.tracksRemoved
+	ps.Unlock()
+
+	if tracksRemoved {
+		ps.r.mixer.removeMixerSlice(slice)
+		ps.logInfo().Str("context", "peer").Str("track", slice.ID()).Msg("peer_tracks_removed")
+	}
 }
 
 func (ps *peerServer) close(cause string) {
@@ -106,6 +120,57 @@ func (ps *peerServer) close(cause string) {
 	}
 }
 
+// pipeline errors and unexpected EOS are sent to the peer, whose tracks are then removed from the
+// mixer (and from other peer connections once signaling is updated). GStreamer messages selected
+// in config/gst.yml are relayed to the peer
+func (ps *peerServer) listenPipeline() {
+	events, messages := ps.pipeline.Events(), ps.pipeline.Messages()
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			ps.ws.sendWithPayload("gstreamer_message", m)
+		case e, ok := <-events:
+			if !ok {
+				// pipeline deleted
+				return
+			}
+			ps.logError().Str("context", "peer").Str("kind", string(e.Kind)).Str("error", e.Message).Msg("pipeline_failed")
+			ps.ws.sendWithPayload("error-pipeline", e)
+			ps.removeTracks()
+		case <-ps.closedCh:
+			return
+		}
+	}
+}
+
+// tracksRemoved is set even if slices do not exist yet, so that they are removed when set
+func (ps *peerServer) removeTracks() {
+	ps.Lock()
+	if ps.tracksRemoved {
+		ps.Unlock()
+		return
+	}
+	ps.tracksRemoved = true
+	slices := []*mixerSlice{ps.audioSlice, ps.videoSlice}
+	ps.Unlock()
+
+	removed := false
+	for _, s := range slices {
+		if s != nil {
+			ps.r.mixer.removeMixerSlice(s)
+			removed = true
+		}
+	}
+	if removed {
+		ps.logInfo().Str("context", "peer").Msg("peer_tracks_removed")
+		go ps.r.mixer.managedUpdateSignaling("pipeline failed for user#"+ps.userId, false)
+	}
+}
+
 func (ps *peerServer) controlFx(payload controlPayload) {
 	interpolatorId := payload.Name + payload.Property
 	interpolator := ps.interpolatorIndex[interpolatorId]
@@ -122,6 +187,7 @@ func (ps *peerServer) controlFx(payload controlPayload) {
 		Float32("value", payload.Value).
 		Int("duration", payload.Duration).
 		Msg("client_fx_control")
+	ps.r.addFxEventRecord(ps, payload.Name, payload.Property, strconv.FormatFloat(float64(payload.Value), 'f', -1, 32), payload.Duration)
 
 	duration := payload.Duration
 	if duration == 0 {
@@ -161,6 +227,7 @@ func (ps *peerServer) controlFx(payload controlPayload) {
 }
 
 func (ps *peerServer) loop() {
+	go ps.listenPipeline()
 
 	// sends "ending" message before rooms does end
 	go func() {
@@ -176,6 +243,20 @@ func (ps *peerServer) loop() {
 		}
 	}()
 
+	// log connection stats
+	go func() {
+		ticker := time.NewTicker(statsPeriod * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ps.pc.logStats()
+			case <-ps.closedCh:
+				return
+			}
+		}
+	}()
+
 	// wait for room end
 	go func() {
 		select {
@@ -236,6 +317,7 @@ func (ps *peerServer) loop() {
 			} else {
 				go func() {
 					ps.pipeline.SetFxPolyProp(payload.Name, payload.Property, payload.Kind, payload.Value)
+					ps.r.addFxEventRecord(ps, payload.Name, payload.Property, payload.Value, payload.Duration)
 					ps.logInfo().
 						Str("context", "track").
 						Str("name", payload.Name).
@@ -296,16 +378,19 @@ func RunPeerServer(origin string, unsafeConn *websocket.Conn) {
 		return
 	}
 
+	// from now on, log to room output
+	ws.logger = log.Output(r.logWriter)
+
 	pc, err := newPeerConn(joinPayload, r)
 	if err != nil {
 		ws.send("error-peer-connection")
-		log.Error().Str("context", "peer").Err(err).Str("namespace", namespace).Str("room", roomId).Str("user", userId).Msg("can't create pc")
+		ws.logger.Error().Str("context", "peer").Err(err).Str("namespace", namespace).Str("room", roomId).Str("user", userId).Msg("can't create pc")
 		return
 	}
 
 	ps := newPeerServer(joinPayload, r, pc, ws)
 
-	log.Info().Str("context", "peer").Str("namespace", namespace).Str("room", roomId).Str("user", userId).Msg("peer_server_started")
+	ws.logger.Info().Str("context", "peer").Str("namespace", namespace).Str("room", roomId).Str("user", userId).Msg("peer_server_started")
 
 	ps.loop() // blocking
 }
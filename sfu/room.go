@@ -2,9 +2,12 @@ package sfu
 
 import (
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/gst"
 	"github.com/creamlab/ducksoup/helpers"
 	"github.com/creamlab/ducksoup/store"
 	"github.com/creamlab/ducksoup/types"
@@ -20,6 +23,8 @@ const (
 	DefaultDuration = 30
 	MaxDuration     = 1200
 	Ending          = 15
+	// filesIndex key for room level recordings
+	roomFilesKey = "_room"
 )
 
 // room holds all the resources of a given experiment, accepting an exact number of *size* attendees
@@ -31,25 +36,35 @@ type room struct {
 	connectedIndex      map[string]bool        // per user id, undefined: never connected, false: previously connected, true: connected
 	joinedCountIndex    map[string]int         // per user id
 	filesIndex          map[string][]string    // per user id, contains media file names
+	pipelines           []*gst.Pipeline        // all pipelines created for this room (including reconnections)
+	connectionRecords   []*types.ManifestConnection
 	running             bool
 	deleted             bool
 	createdAt           time.Time
 	startedAt           time.Time
+	endedAt             time.Time
 	inTracksReadyCount  int
 	outTracksReadyCount int
+	shuttingDown        bool
 	// channels (safe)
 	waitForAllCh chan struct{}
 	endCh        chan struct{}
+	shutdownCh   chan struct{} // closed to end room before its duration is over
+	finalizedCh  chan struct{}
 	// other (written only during initialization)
-	id           string
-	qualifiedId  string // prefixed by origin, used for indexing in roomStore
-	namespace    string
-	size         int
-	duration     int
-	neededTracks int
-	ssrcs        []uint32
+	id            string
+	qualifiedId   string            // prefixed by origin, used for indexing in roomStore
+	join          types.JoinPayload // join payload of the user who created the room
+	namespace     string
+	size          int
+	duration      int
+	neededTracks  int
+	roomRecording bool
+	ssrcs         []uint32
 	// log
-	logger zerolog.Logger
+	logger    zerolog.Logger
+	logWriter io.Writer        // global output and room log file
+	logFile   *helpers.LogFile // nil if it could not be opened
 }
 
 // private and not guarded by mutex locks, since called by other guarded methods
@@ -93,20 +108,33 @@ func newRoom(qualifiedId string, join types.JoinPayload) *room {
 		joinedCountIndex:    joinedCountIndex,
 		waitForAllCh:        make(chan struct{}),
 		endCh:               make(chan struct{}),
+		shutdownCh:          make(chan struct{}),
+		finalizedCh:         make(chan struct{}),
 		createdAt:           time.Now(),
 		inTracksReadyCount:  0,
 		outTracksReadyCount: 0,
 		qualifiedId:         qualifiedId,
 		id:                  join.RoomId,
+		join:                join,
 		namespace:           join.Namespace,
 		size:                size,
 		duration:            duration,
 		neededTracks:        size * TracksPerPeer,
+		roomRecording:       join.RoomRecording,
 		ssrcs:               []uint32{},
 	}
 	r.mixer = newMixer(r)
+	// log to room file in addition to global output
+	r.logWriter = helpers.LogWriter()
+	logFile, err := helpers.OpenLogFile("data/" + r.logPath())
+	if err != nil {
+		log.Error().Err(err).Str("context", "room").Str("namespace", join.Namespace).Str("room", join.RoomId).Msg("room_log_open_failed")
+	} else {
+		r.logFile = logFile
+		r.logWriter = helpers.LogWriterWith(logFile)
+	}
 	// log (call Run hook whenever logging)
-	r.logger = log.With().
+	r.logger = log.Output(r.logWriter).With().
 		Str("context", "room").
 		Str("namespace", join.Namespace).
 		Str("room", join.RoomId).
@@ -134,6 +162,22 @@ func (r *room) connectedUserCount() (count int) {
 	return len(r.peerServerIndex)
 }
 
+// one of "waiting", "running", "ending", "ended"
+func (r *room) state() string {
+	select {
+	case <-r.endCh:
+		return "ended"
+	default:
+	}
+	if !r.running {
+		return "waiting"
+	}
+	if time.Since(r.startedAt) > time.Duration(r.duration-Ending)*time.Second {
+		return "ending"
+	}
+	return "running"
+}
+
 func (r *room) filePrefixWithCount(join types.JoinPayload) string {
 	connectionCount := r.joinedCountForUser(join.UserId)
 	// time room user count
@@ -144,18 +188,51 @@ func (r *room) filePrefixWithCount(join types.JoinPayload) string {
 		"-c-" + fmt.Sprint(connectionCount)
 }
 
+// room level files (not related to a given user) share the same prefix
+func (r *room) roomFilePrefix() string {
+	return r.startedAt.Format("20060102-150405.000") +
+		"-n-" + r.namespace +
+		"-r-" + r.id
+}
+
+// identifies a room lifetime (room ids are reused), from its creation
+func (r *room) sessionId() string {
+	return r.createdAt.Format("20060102-150405.000") +
+		"-n-" + r.namespace +
+		"-r-" + r.id
+}
+
+// relative to data folder, named after room creation (room may not start)
+func (r *room) logPath() string {
+	return r.namespace + "/logs/" + r.sessionId() + ".log"
+}
+
+func (r *room) closeLog() {
+	if r.logFile != nil {
+		r.logFile.Close()
+	}
+}
+
 func (r *room) countdown() {
 	// blocking "end" event and delete
 	endTimer := time.NewTimer(time.Duration(r.duration) * time.Second)
-	<-endTimer.C
+	select {
+	case <-endTimer.C:
+	case <-r.shutdownCh:
+		endTimer.Stop()
+	}
 
 	r.Lock()
 	r.running = false
+	r.endedAt = time.Now()
 	r.Unlock()
 
 	r.logger.Info().Msg("room_ended")
+	events.Publish(events.RoomEnded, r.namespace, r.id, "", nil)
 	// listened by peerServers, mixer, mixerTracks
 	close(r.endCh)
+	r.setFinalizing(true)
+	go r.finalize()
 	// actual deleting is done when all users have disconnected, see disconnectUser
 	// except when room was already empty (started but peers left)
 	<-time.After(3000 * time.Millisecond)
@@ -179,6 +256,10 @@ func (r *room) incInTracksReadyCount(fromPs *peerServer, remoteTrack *webrtc.Tra
 
 	r.inTracksReadyCount++
 	r.logger.Info().Int("count", r.inTracksReadyCount).Msg("room_track_added")
+	events.Publish(events.TrackAdded, r.namespace, r.id, fromPs.userId, map[string]interface{}{
+		"kind":  remoteTrack.Kind().String(),
+		"count": r.inTracksReadyCount,
+	})
 
 	if r.inTracksReadyCount == r.neededTracks {
 		// do start
@@ -186,6 +267,7 @@ func (r *room) incInTracksReadyCount(fromPs *peerServer, remoteTrack *webrtc.Tra
 		r.running = true
 		r.logger.Info().Msg("room_started")
 		r.startedAt = time.Now()
+		events.Publish(events.RoomStarted, r.namespace, r.id, "", map[string]interface{}{"startedAt": r.startedAt})
 		// send start to all peers
 		for _, ps := range r.peerServerIndex {
 			go ps.ws.send("start")
@@ -231,6 +313,7 @@ func (r *room) connectPeerServer(ps *peerServer) {
 	}()
 
 	r.peerServerIndex[ps.userId] = ps
+	r.pipelines = append(r.pipelines, ps.pipeline)
 }
 
 func (r *room) deleteIfEmpty() {
@@ -246,6 +329,11 @@ func (r *room) deleteIfEmpty() {
 func (r *room) delete() {
 	roomStoreSingleton.delete(r)
 	r.logger.Info().Msg("room_deleted")
+	events.Publish(events.RoomDeleted, r.namespace, r.id, "", nil)
+	// otherwise closed when finalized
+	if r.startedAt.IsZero() {
+		r.closeLog()
+	}
 	// cleanup
 	for _, ssrc := range r.ssrcs {
 		store.RemoveFromSSRCIndex(ssrc)
@@ -262,6 +350,8 @@ func (r *room) disconnectUser(userId string) {
 		delete(r.peerServerIndex, userId)
 		// mark disconnected, but keep track of her
 		r.connectedIndex[userId] = false
+		r.logger.Info().Str("user", userId).Msg("peer_disconnected")
+		events.Publish(events.PeerDisconnected, r.namespace, r.id, userId, nil)
 		go r.mixer.managedUpdateSignaling("disconnected", false)
 
 		// don't delete only if is empty since users may have disconnected temporarily
@@ -4,8 +4,8 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/creamlab/ducksoup/events"
 	"github.com/creamlab/ducksoup/types"
-	"github.com/rs/zerolog/log"
 )
 
 var (
@@ -16,6 +16,8 @@ var (
 type roomStore struct {
 	sync.Mutex
 	index map[string]*room
+	// no more rooms or users accepted, only reconnections to running rooms
+	closed bool
 }
 
 func init() {
@@ -23,7 +25,7 @@ func init() {
 }
 
 func newRoomStore() *roomStore {
-	return &roomStore{sync.Mutex{}, make(map[string]*room)}
+	return &roomStore{sync.Mutex{}, make(map[string]*room), false}
 }
 
 func (rs *roomStore) join(join types.JoinPayload) (*room, error) {
@@ -41,27 +43,48 @@ func (rs *roomStore) join(join types.JoinPayload) (*room, error) {
 			// ok -> same user has previously connected
 			if connected {
 				// user is currently connected (second browser tab or device) -> forbidden
+				events.Publish(events.JoinRejected, r.namespace, r.id, userId, map[string]interface{}{"reason": "duplicate"})
 				return nil, errors.New("duplicate")
+			} else if rs.closed && !r.running {
+				events.Publish(events.JoinRejected, r.namespace, r.id, userId, map[string]interface{}{"reason": "shutdown"})
+				return nil, errors.New("shutdown")
 			} else {
 				// reconnects (for instance: page reload)
 				r.connectedIndex[userId] = true
 				r.joinedCountIndex[userId]++
+				r.logger.Info().Str("user", userId).Int("joinedCount", r.joinedCountIndex[userId]).Interface("payload", join).Msg("peer_joined")
+				events.Publish(events.PeerJoined, r.namespace, r.id, userId, map[string]interface{}{"joinedCount": r.joinedCountIndex[userId], "payload": join})
 				return r, nil
 			}
 		} else if r.userCount() == r.size {
 			// room limit reached
+			events.Publish(events.JoinRejected, r.namespace, r.id, userId, map[string]interface{}{"reason": "full"})
 			return nil, errors.New("full")
+		} else if rs.closed {
+			events.Publish(events.JoinRejected, r.namespace, r.id, userId, map[string]interface{}{"reason": "shutdown"})
+			return nil, errors.New("shutdown")
 		} else {
 			// new user joined existing room
 			r.connectedIndex[userId] = true
 			r.joinedCountIndex[userId] = 1
-			log.Info().Str("context", "room").Str("namespace", join.Namespace).Str("room", join.RoomId).Str("user", userId).Interface("payload", join).Msg("peer_joined")
+			r.logger.Info().Str("user", userId).Int("joinedCount", 1).Interface("payload", join).Msg("peer_joined")
+			events.Publish(events.PeerJoined, r.namespace, r.id, userId, map[string]interface{}{"joinedCount": 1, "payload": join})
 			return r, nil
 		}
+	} else if rs.closed {
+		events.Publish(events.JoinRejected, join.Namespace, join.RoomId, userId, map[string]interface{}{"reason": "shutdown"})
+		return nil, errors.New("shutdown")
 	} else {
 		newRoom := newRoom(qualifiedId, join)
-		log.Info().Str("context", "room").Str("namespace", join.Namespace).Str("room", join.RoomId).Str("user", userId).Str("qualifiedId", qualifiedId).Str("origin", join.Origin).Msg("room_created")
-		log.Info().Str("context", "room").Str("namespace", join.Namespace).Str("room", join.RoomId).Str("user", userId).Interface("payload", join).Msg("peer_joined")
+		newRoom.logger.Info().Str("user", userId).Str("qualifiedId", qualifiedId).Str("origin", join.Origin).Msg("room_created")
+		events.Publish(events.RoomCreated, newRoom.namespace, newRoom.id, userId, map[string]interface{}{
+			"session":  newRoom.sessionId(),
+			"origin":   join.Origin,
+			"size":     newRoom.size,
+			"duration": newRoom.duration,
+		})
+		newRoom.logger.Info().Str("user", userId).Int("joinedCount", 1).Interface("payload", join).Msg("peer_joined")
+		events.Publish(events.PeerJoined, newRoom.namespace, newRoom.id, userId, map[string]interface{}{"joinedCount": 1, "payload": join})
 		roomStoreSingleton.index[qualifiedId] = newRoom
 		return newRoom, nil
 	}
@@ -73,3 +96,16 @@ func (rs *roomStore) delete(r *room) {
 
 	delete(rs.index, r.qualifiedId)
 }
+
+// close stops accepting joins (except reconnections to running rooms) and returns current rooms
+func (rs *roomStore) close() []*room {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.closed = true
+	rooms := make([]*room, 0, len(rs.index))
+	for _, r := range rs.index {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
@@ -105,4 +105,47 @@ func TestJoinRoom(t *testing.T) {
 		}
 	})
 
+	t.Run("Reject joins on shutdown", func(t *testing.T) {
+		joinPayload1 := newJoinPayload("https://origin", "room-shutdown", "user-1", "mirror", 2)
+		joinPayload2 := newJoinPayload("https://origin", "room-shutdown", "user-2", "mirror", 2)
+		joinPayloadNew := newJoinPayload("https://origin", "room-shutdown-new", "user-1", "mirror", 2)
+
+		roomStoreSingleton.join(joinPayload1)
+		roomStoreSingleton.close()
+		defer func() {
+			roomStoreSingleton.Lock()
+			roomStoreSingleton.closed = false
+			roomStoreSingleton.Unlock()
+		}()
+
+		if _, err := roomStoreSingleton.join(joinPayload2); err == nil || err.Error() != "shutdown" {
+			t.Error("user #2 should not join on shutdown")
+		}
+		if _, err := roomStoreSingleton.join(joinPayloadNew); err == nil || err.Error() != "shutdown" {
+			t.Error("room should not be created on shutdown")
+		}
+	})
+
+}
+
+func TestInspectRoom(t *testing.T) {
+	t.Run("Report waiting rooms", func(t *testing.T) {
+		joinPayload := newJoinPayload("https://origin", "room-waiting", "user-1", "mirror", 2)
+
+		r, err := roomStoreSingleton.join(joinPayload)
+		if err != nil {
+			t.Fatal("user #1 joined failed")
+		}
+
+		report, ok := roomStoreSingleton.inspect().(map[string]interface{})
+		if !ok {
+			t.Fatal("inspect should report rooms")
+		}
+		if _, ok := report[r.qualifiedId]; !ok {
+			t.Error("waiting room missing from inspect report")
+		}
+		if r.state() != "waiting" {
+			t.Errorf("room state should be waiting, got %v", r.state())
+		}
+	})
 }
@@ -3,12 +3,16 @@ package sfu
 import (
 	"io"
 	"sync"
+	"time"
 
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog"
 )
 
+// RTT values above 10 seconds (in 1/65536 seconds) are considered implausible and discarded
+const maxRTT = 10 << 16
+
 type senderController struct {
 	sync.Mutex
 	slice          *mixerSlice
@@ -19,6 +23,11 @@ type senderController struct {
 	sender         *webrtc.RTPSender
 	optimalBitrate uint64
 	maxBitrate     uint64
+	// stats from last receiver report
+	jitter       float64 // ms
+	fractionLost uint8   // over 256
+	totalLost    uint32
+	rtt          time.Duration // zero until known
 }
 
 func newSenderController(sender *webrtc.RTPSender, slice *mixerSlice, toUserId string) *senderController {
@@ -50,6 +59,60 @@ func (sc *senderController) logInfo() *zerolog.Event {
 	return sc.slice.logInfo().Str("context", "track").Str("toUser", sc.toUserId)
 }
 
+func (sc *senderController) updateStatsFromReport(r rtcp.ReceptionReport) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	clockRate := sc.slice.output.Codec().ClockRate
+	if clockRate > 0 {
+		sc.jitter = float64(r.Jitter) / float64(clockRate) * 1000
+	}
+	sc.fractionLost = r.FractionLost
+	sc.totalLost = r.TotalLost
+	// RTT as described in https://datatracker.ietf.org/doc/html/rfc3550#section-6.4.1
+	// (LSR and DLSR are expressed in 1/65536 seconds), LSR is 0 until the recipient receives a sender report
+	if r.LastSenderReport != 0 {
+		now := uint32(timeToNTP(time.Now()) >> 16)
+		// signed to detect clock discrepancies or stale reports instead of wrapping around
+		rtt := int32(now - r.LastSenderReport - r.Delay)
+		if rtt > 0 && rtt <= maxRTT {
+			sc.rtt = time.Duration(float64(rtt) / 65536 * float64(time.Second))
+		}
+	}
+}
+
+func (sc *senderController) inspect() interface{} {
+	sc.Lock()
+	defer sc.Unlock()
+
+	// capitalize for JSON export
+	return struct {
+		JitterMs     float64
+		FractionLost float64
+		TotalLost    uint32
+		RttMs        int64
+	}{
+		sc.jitter,
+		float64(sc.fractionLost) / 256,
+		sc.totalLost,
+		sc.rtt.Milliseconds(),
+	}
+}
+
+func (sc *senderController) logStats() {
+	sc.Lock()
+	defer sc.Unlock()
+
+	e := sc.slice.logDebug().Str("toUser", sc.toUserId).
+		Float64("jitter", sc.jitter).
+		Float64("fractionLost", float64(sc.fractionLost)/256).
+		Uint32("totalLost", sc.totalLost)
+	if sc.rtt > 0 {
+		e = e.Int64("rtt", sc.rtt.Milliseconds())
+	}
+	e.Str("unit", "ms").Msg(sc.kind + "_out_reception_estimated")
+}
+
 // see https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02
 // credits to https://github.com/jech/galene
 func (sc *senderController) updateRateFromLoss(loss uint8) {
@@ -110,13 +173,17 @@ func (sc *senderController) runListener() {
 				// TODO could implement GCC from TWCC
 				switch rtcpPacket := packet.(type) {
 				case *rtcp.PictureLossIndication:
-					sc.slice.fromPs.pc.throttledPLIRequest("PLI from other peer")
+					sc.slice.requestKeyFrame("PLI from other peer")
+				case *rtcp.FullIntraRequest:
+					sc.slice.requestKeyFrame("FIR from other peer")
 				case *rtcp.ReceiverEstimatedMaximumBitrate:
 					// sc.updateRateFromREMB(uint64(rtcpPacket.Bitrate))
 				case *rtcp.ReceiverReport:
 					for _, r := range rtcpPacket.Reports {
 						if r.SSRC == uint32(sc.ssrc) {
+							fractionLostHistogram.WithLabelValues(sc.kind).Observe(float64(r.FractionLost) / 256)
 							sc.updateRateFromLoss(r.FractionLost)
+							sc.updateStatsFromReport(r)
 						}
 					}
 				}
@@ -1,19 +1,23 @@
 package sfu
 
+import "time"
+
 func Inspect() interface{} {
 	return roomStoreSingleton.inspect()
 }
 
 func (rs *roomStore) inspect() interface{} {
+	// don't hold store lock while locking rooms (see roomStoreCollector)
 	rs.Lock()
-	defer rs.Unlock()
+	rooms := make([]*room, 0, len(rs.index))
+	for _, r := range rs.index {
+		rooms = append(rooms, r)
+	}
+	rs.Unlock()
 
 	report := make(map[string]interface{})
-	for _, room := range rs.index {
-		mixerReport := room.mixer.inspect()
-		if mixerReport != nil {
-			report[room.qualifiedId] = mixerReport
-		}
+	for _, r := range rooms {
+		report[r.qualifiedId] = r.inspect()
 	}
 	if len(report) > 0 {
 		return report
@@ -21,7 +25,87 @@ func (rs *roomStore) inspect() interface{} {
 	return nil
 }
 
+func (r *room) inspect() interface{} {
+	r.RLock()
+	users := make(map[string]interface{})
+	for userId, connected := range r.connectedIndex {
+		// capitalize for JSON export
+		users[userId] = struct {
+			Connected   bool
+			JoinedCount int
+		}{
+			connected,
+			r.joinedCountIndex[userId],
+		}
+	}
+	files := make(map[string][]string)
+	for userId, userFiles := range r.filesIndex {
+		files[userId] = append([]string{}, userFiles...)
+	}
+	var elapsed, remaining int
+	if !r.startedAt.IsZero() {
+		end := time.Now()
+		if !r.endedAt.IsZero() {
+			end = r.endedAt
+		}
+		elapsed = int(end.Sub(r.startedAt).Seconds())
+		remaining = r.duration - elapsed
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	state := r.state()
+	readyTracks := r.inTracksReadyCount
+	r.RUnlock()
+
+	return struct {
+		State            string
+		Namespace        string
+		Origin           string
+		RecordingMode    string
+		Size             int
+		NeededTracks     int
+		ReadyTracks      int
+		Duration         int
+		ElapsedSeconds   int
+		RemainingSeconds int
+		Users            map[string]interface{}
+		Files            map[string][]string
+		Slices           interface{}
+		Peers            interface{}
+	}{
+		state,
+		r.namespace,
+		r.join.Origin,
+		r.join.RecordingMode,
+		r.size,
+		r.neededTracks,
+		readyTracks,
+		r.duration,
+		elapsed,
+		remaining,
+		users,
+		files,
+		r.mixer.inspect(),
+		r.inspectPeers(),
+	}
+}
+
+func (r *room) inspectPeers() interface{} {
+	r.RLock()
+	defer r.RUnlock()
+
+	report := make(map[string]interface{})
+	for userId, ps := range r.peerServerIndex {
+		report[userId] = ps.pc.inspect()
+	}
+	return report
+}
+
 func (m *mixer) inspect() interface{} {
+	m.RLock()
+	defer m.RUnlock()
+
 	report := make(map[string]interface{})
 	for _, slice := range m.sliceIndex {
 		report[slice.ID()] = slice.inspect()
@@ -33,18 +117,41 @@ func (m *mixer) inspect() interface{} {
 }
 
 func (s *mixerSlice) inspect() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	recipients := make(map[string]interface{})
+	for toUserId, sc := range s.senderControllerIndex {
+		recipients[toUserId] = sc.inspect()
+	}
+
 	// capitalize for JSON export
 	return struct {
-		From      string
-		Kind      string
-		IntputKbs uint64
-		OutputKbs uint64
-		TargetKbs uint64
+		From       string
+		Kind       string
+		IntputKbs  uint64
+		OutputKbs  uint64
+		TargetKbs  uint64
+		Recipients map[string]interface{}
 	}{
 		s.fromPs.userId,
 		s.input.Kind().String(),
 		s.inputBitrate / 1000,
 		s.outputBitrate / 1000,
 		s.optimalBitrate / 1000,
+		recipients,
+	}
+}
+
+// IsRoomActive tells if a room with the given namespace and id is in the store (whatever its origin)
+func IsRoomActive(namespace, roomId string) bool {
+	roomStoreSingleton.Lock()
+	defer roomStoreSingleton.Unlock()
+
+	for _, r := range roomStoreSingleton.index {
+		if r.namespace == namespace && r.id == roomId {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,144 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/gst"
+	"github.com/rs/zerolog/log"
+)
+
+// rooms being finalized, that may have been deleted from roomStore meanwhile
+var finalizing = struct {
+	sync.Mutex
+	index map[*room]bool
+}{index: make(map[*room]bool)}
+
+func (r *room) setFinalizing(value bool) {
+	finalizing.Lock()
+	defer finalizing.Unlock()
+
+	if value {
+		finalizing.index[r] = true
+	} else {
+		delete(finalizing.index, r)
+	}
+}
+
+func (r *room) isStarted() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return !r.startedAt.IsZero()
+}
+
+// shutdown notifies connected peers and ends the room: a started room ends (and is finalized) as if
+// its duration was over, peers of a room that has not started are disconnected
+func (r *room) shutdown() {
+	select {
+	case <-r.endCh:
+		// already ended
+		return
+	default:
+	}
+
+	r.RLock()
+	peerServers := make([]*peerServer, 0, len(r.peerServerIndex))
+	for _, ps := range r.peerServerIndex {
+		peerServers = append(peerServers, ps)
+	}
+	started := !r.startedAt.IsZero()
+	r.RUnlock()
+
+	r.logger.Info().Msg("room_shutdown")
+	for _, ps := range peerServers {
+		ps.ws.send("server_shutdown")
+	}
+	if started {
+		r.Lock()
+		if !r.shuttingDown {
+			r.shuttingDown = true
+			close(r.shutdownCh)
+		}
+		r.Unlock()
+	} else {
+		for _, ps := range peerServers {
+			ps.close("server shutdown")
+		}
+	}
+}
+
+// Shutdown stops accepting joins (reconnections to running rooms excepted), ends rooms and blocks
+// until their recordings are finalized and processed by event subscribers (uploads, webhooks), or
+// timeouts are reached. Running rooms may be waited for before being ended, see config/sfu.yml
+func Shutdown() {
+	rooms := roomStoreSingleton.close()
+	log.Info().Str("context", "app").Int("rooms", len(rooms)).Msg("joins_closed")
+
+	// rooms that have not started are not waited for
+	started := []*room{}
+	for _, r := range rooms {
+		if r.isStarted() {
+			started = append(started, r)
+		} else {
+			r.shutdown()
+		}
+	}
+
+	if config.Shutdown.WaitForRooms && len(started) > 0 {
+		log.Info().Str("context", "app").Int("rooms", len(started)).Msg("running_rooms_waited")
+		deadline := time.After(time.Duration(config.Shutdown.RoomsTimeout) * time.Second)
+	wait:
+		for _, r := range started {
+			select {
+			case <-r.endCh:
+			case <-deadline:
+				log.Error().Str("context", "app").Msg("running_rooms_wait_timed_out")
+				break wait
+			}
+		}
+	}
+
+	// rooms already ended are only finalized
+	for _, r := range started {
+		r.shutdown()
+	}
+	// including rooms that ended before shutdown and have been deleted since
+	finalized := make(map[*room]bool)
+	finalizing.Lock()
+	for r := range finalizing.index {
+		finalized[r] = true
+	}
+	finalizing.Unlock()
+	for _, r := range started {
+		finalized[r] = true
+	}
+	deadline := time.After(time.Duration(config.Shutdown.FinalizeTimeout) * time.Second)
+	timedOut := false
+	for r := range finalized {
+		if !timedOut {
+			select {
+			case <-r.finalizedCh:
+				continue
+			case <-deadline:
+				timedOut = true
+			}
+		}
+		select {
+		case <-r.finalizedCh:
+		default:
+			r.logger.Error().Msg("room_finalize_timed_out")
+		}
+	}
+
+	// remaining pipelines, for instance of rooms that have not started
+	gst.StopPipelines()
+	if !gst.WaitForPipelines(pipelinesTimeout) {
+		log.Error().Str("context", "app").Msg("pipelines_wait_timed_out")
+	}
+	if !events.Drain(time.Duration(config.Shutdown.EventsTimeout) * time.Second) {
+		log.Error().Str("context", "app").Msg("events_wait_timed_out")
+	}
+	log.Info().Str("context", "app").Msg("rooms_shutdown")
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/creamlab/ducksoup/helpers"
 	"github.com/creamlab/ducksoup/types"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
@@ -25,6 +26,7 @@ type wsConn struct {
 	userId    string
 	roomId    string
 	namespace string
+	logger    zerolog.Logger // global logger, then room output once joined
 }
 
 type messageOut struct {
@@ -75,7 +77,7 @@ func parseVideoFormat(join types.JoinPayload) (videoFormat string) {
 
 func parseRecordingMode(join types.JoinPayload) (recordingMode string) {
 	recordingMode = join.RecordingMode
-	if recordingMode != "muxed" && recordingMode != "split" && recordingMode != "passthrough" && recordingMode != "none" {
+	if recordingMode != "muxed" && recordingMode != "segmented" && recordingMode != "split" && recordingMode != "passthrough" && recordingMode != "none" {
 		recordingMode = defaultRecordingMode
 	}
 	return
@@ -108,22 +110,34 @@ func parseFrameRate(join types.JoinPayload) (frameRate int) {
 // API
 
 func newWsConn(unsafeConn *websocket.Conn) *wsConn {
-	return &wsConn{sync.Mutex{}, unsafeConn, time.Now(), "", "", ""}
+	return &wsConn{sync.Mutex{}, unsafeConn, time.Now(), "", "", "", log.Logger}
 }
 
 func (ws *wsConn) logError() *zerolog.Event {
-	return log.Error().Str("context", "peer").Str("namespace", ws.namespace).Str("room", ws.roomId).Str("user", ws.userId)
+	return ws.logger.Error().Str("context", "peer").Str("namespace", ws.namespace).Str("room", ws.roomId).Str("user", ws.userId)
 }
 
 func (ws *wsConn) read() (m messageIn, err error) {
 	err = ws.ReadJSON(&m)
 
 	if err != nil && websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		wsErrorCounter.Inc()
 		ws.logError().Err(err).Msg("can't read JSON")
 	}
 	return
 }
 
+// StoredUserIds lists the ids that may identify a user in files, logs and manifests: the
+// current one first (pseudonymized if enabled), then the cleaned one if it differs
+func StoredUserIds(userId string) []string {
+	clean := parseString(userId)
+	pseudonym := helpers.Pseudonymize(clean)
+	if pseudonym == clean {
+		return []string{clean}
+	}
+	return []string{pseudonym, clean}
+}
+
 func (ws *wsConn) readJoin(origin string) (join types.JoinPayload, err error) {
 	var m messageIn
 
@@ -136,7 +150,7 @@ func (ws *wsConn) readJoin(origin string) (join types.JoinPayload, err error) {
 	err = json.Unmarshal([]byte(m.Payload), &join)
 	// restrict to authorized values
 	join.RoomId = parseString(join.RoomId)
-	join.UserId = parseString(join.UserId)
+	join.UserId = StoredUserIds(join.UserId)[0]
 	join.Namespace = parseString(join.Namespace)
 	join.VideoFormat = parseVideoFormat(join)
 	join.RecordingMode = parseRecordingMode(join)
@@ -160,6 +174,7 @@ func (ws *wsConn) send(text string) (err error) {
 
 	m := &messageOut{Kind: text}
 	if err := ws.Conn.WriteJSON(m); err != nil {
+		wsErrorCounter.Inc()
 		ws.logError().Err(err).Str("out", fmt.Sprintf("%+v", m)).Msg("json_write_failed")
 	}
 	return
@@ -174,6 +189,7 @@ func (ws *wsConn) sendWithPayload(kind string, payload interface{}) (err error)
 		Payload: payload,
 	}
 	if err := ws.Conn.WriteJSON(m); err != nil {
+		wsErrorCounter.Inc()
 		ws.logError().Err(err).Str("out", fmt.Sprintf("%+v", m)).Msg("json_write_with_payload_failed")
 	}
 	return
@@ -0,0 +1,37 @@
+package stats
+
+import (
+	"github.com/creamlab/ducksoup/events"
+	"github.com/gorilla/websocket"
+)
+
+// RunEventsServer streams events (all events if kinds is empty) until websocket is closed
+func RunEventsServer(ws *websocket.Conn, kinds []events.Kind) {
+	defer ws.Close()
+
+	s := events.Subscribe(kinds...)
+	defer s.Unsubscribe()
+
+	// incoming messages are ignored, but reading is needed to detect closing
+	closedCh := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				close(closedCh)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e := <-s.C:
+			m := &messageOut{Kind: "event", Payload: e}
+			if err := ws.WriteJSON(m); err != nil {
+				return
+			}
+		case <-closedCh:
+			return
+		}
+	}
+}
@@ -25,12 +25,10 @@ func RunStatsServer(ws *websocket.Conn) {
 	ticker := time.NewTicker(period * time.Millisecond)
 
 	for range ticker.C {
-		payload := sfu.Inspect()
-		if payload != nil {
-			m := &messageOut{Kind: "update", Payload: payload}
-			if err := ws.WriteJSON(m); err != nil {
-				break
-			}
+		// sent even if there are no rooms, so that clients don't display stale data
+		m := &messageOut{Kind: "update", Payload: sfu.Inspect()}
+		if err := ws.WriteJSON(m); err != nil {
+			break
 		}
 	}
 
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+type s3Config struct {
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	UseSSL   bool   `yaml:"useSSL"`
+	Prefix   string `yaml:"prefix"`
+}
+
+type storageConfig struct {
+	Backend     string   `yaml:"backend"`
+	DeleteLocal bool     `yaml:"deleteLocal"`
+	MaxAttempts int      `yaml:"maxAttempts"`
+	S3          s3Config `yaml:"s3"`
+}
+
+var config storageConfig
+
+func init() {
+	f, err := helpers.Open("config/storage.yml")
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	err = decoder.Decode(&config)
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+}
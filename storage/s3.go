@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Backend works with any S3-compatible store (AWS, MinIO...)
+type s3Backend struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Backend() (Backend, error) {
+	accessKey := helpers.Getenv("DS_S3_ACCESS_KEY")
+	secretKey := helpers.Getenv("DS_S3_SECRET_KEY")
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return nil, errors.New("DS_S3_ACCESS_KEY and DS_S3_SECRET_KEY are required")
+	}
+	client, err := minio.New(config.S3.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: config.S3.UseSSL,
+		Region: config.S3.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	exists, err := client.BucketExists(context.Background(), config.S3.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %v not found", config.S3.Bucket)
+	}
+	return &s3Backend{client, config.S3.Bucket, config.S3.Prefix}, nil
+}
+
+func (b *s3Backend) Upload(ctx context.Context, f File) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	key := b.prefix + f.Key
+	// Content-MD5 lets the store reject corrupted uploads (checked per part for multipart uploads)
+	_, err = b.client.PutObject(ctx, b.bucket, key, file, f.Size, minio.PutObjectOptions{
+		SendContentMd5: true,
+		UserMetadata:   map[string]string{"sha256": f.SHA256},
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if info.Size != f.Size {
+		return fmt.Errorf("stored size %v differs from local size %v", info.Size, f.Size)
+	}
+	// ETag is the MD5 of the object, except for multipart uploads (ETag then contains "-")
+	if !strings.Contains(info.ETag, "-") && info.ETag != f.MD5 {
+		return fmt.Errorf("stored checksum %v differs from local checksum %v", info.ETag, f.MD5)
+	}
+	return nil
+}
+
+func (b *s3Backend) Remove(ctx context.Context, key string) error {
+	// no error if object does not exist
+	return b.client.RemoveObject(ctx, b.bucket, b.prefix+key, minio.RemoveObjectOptions{})
+}
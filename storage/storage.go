@@ -0,0 +1,216 @@
+// Package storage uploads finalized session files (recordings, manifest and room log) to a remote backend
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dataFolder     = "data/"
+	initialBackoff = 2 * time.Second
+)
+
+// File to be uploaded, key is the path relative to data folder
+type File struct {
+	Key    string
+	Path   string
+	Size   int64
+	MD5    string // hex encoded
+	SHA256 string // hex encoded
+}
+
+// Backend uploads a file and checks that the stored object matches it (size and checksum)
+type Backend interface {
+	Upload(ctx context.Context, f File) error
+	Remove(ctx context.Context, key string) error
+}
+
+var backendConstructors = map[string]func() (Backend, error){
+	"s3": newS3Backend,
+}
+
+// FileStatus and Report are sent as FilesUploaded event data
+type FileStatus struct {
+	Path     string `json:"path"` // relative to data folder
+	Size     int64  `json:"size"`
+	Uploaded bool   `json:"uploaded"`
+	Deleted  bool   `json:"deleted"` // local file has been removed
+	Error    string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Session  string       `json:"session"` // same as FilesReady one
+	Backend  string       `json:"backend"`
+	Complete bool         `json:"complete"` // all files have been uploaded
+	Files    []FileStatus `json:"files"`
+}
+
+var (
+	backendOnce       sync.Once
+	configuredBackend Backend
+	backendErr        error
+)
+
+// nil if no backend is configured
+func getBackend() (Backend, error) {
+	backendOnce.Do(func() {
+		if len(config.Backend) == 0 {
+			return
+		}
+		constructor, ok := backendConstructors[config.Backend]
+		if !ok {
+			backendErr = fmt.Errorf("unknown storage backend: %v", config.Backend)
+			return
+		}
+		configuredBackend, backendErr = constructor()
+	})
+	return configuredBackend, backendErr
+}
+
+// Start subscribes to FilesReady events if a backend is configured in config/storage.yml
+func Start() {
+	backend, err := getBackend()
+	if err != nil {
+		log.Error().Str("context", "init").Str("backend", config.Backend).Err(err).Msg("storage_backend_failed")
+		return
+	}
+	if backend == nil {
+		return
+	}
+	log.Info().Str("context", "init").Str("backend", config.Backend).Bool("deleteLocal", config.DeleteLocal).Msg("storage_enabled")
+
+	// uploads are waited for on shutdown
+	s := events.SubscribeTracked(events.FilesReady)
+	go func() {
+		for e := range s.C {
+			data, ok := e.Data.(events.FilesData)
+			if !ok {
+				s.Done()
+				continue
+			}
+			go func(e events.Event) {
+				defer s.Done()
+				upload(backend, e, data)
+			}(e)
+		}
+	}()
+}
+
+func newFile(path string) (f File, err error) {
+	f = File{Key: path, Path: dataFolder + path}
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	f.Size, err = io.Copy(io.MultiWriter(md5Hash, sha256Hash), file)
+	if err != nil {
+		return
+	}
+	f.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+	f.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	return
+}
+
+func uploadWithRetry(backend Backend, f File) (err error) {
+	backoff := initialBackoff
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		if err = backend.Upload(context.Background(), f); err == nil {
+			return
+		}
+		if attempt < config.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("%v attempts failed, last error: %w", config.MaxAttempts, err)
+}
+
+// Replace uploads again a file (path relative to data folder) that has been modified locally, if
+// a backend is configured
+func Replace(path string) error {
+	backend, err := getBackend()
+	if err != nil || backend == nil {
+		return err
+	}
+	f, err := newFile(path)
+	if err != nil {
+		return err
+	}
+	return uploadWithRetry(backend, f)
+}
+
+// Remove deletes the stored copy of a file (path relative to data folder), if a backend is configured
+func Remove(path string) error {
+	backend, err := getBackend()
+	if err != nil || backend == nil {
+		return err
+	}
+	return backend.Remove(context.Background(), path)
+}
+
+// files of a session are uploaded sequentially, sessions concurrently
+func upload(backend Backend, e events.Event, data events.FilesData) {
+	logger := log.With().
+		Str("context", "storage").
+		Str("namespace", e.Namespace).
+		Str("room", e.RoomId).
+		Logger()
+
+	paths := []string{}
+	for _, userFiles := range data.Files {
+		paths = append(paths, userFiles...)
+	}
+	for _, path := range []string{data.Manifest, data.Log} {
+		if len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+
+	report := Report{Session: data.Session, Backend: config.Backend, Complete: true, Files: []FileStatus{}}
+	for _, path := range paths {
+		status := FileStatus{Path: path}
+		f, err := newFile(path)
+		if err == nil {
+			status.Size = f.Size
+			err = uploadWithRetry(backend, f)
+		}
+		if err != nil {
+			status.Error = err.Error()
+			report.Complete = false
+			logger.Error().Str("file", path).Err(err).Msg("file_upload_failed")
+		} else {
+			status.Uploaded = true
+			logger.Info().Str("file", path).Int64("size", f.Size).Msg("file_uploaded")
+		}
+		report.Files = append(report.Files, status)
+	}
+
+	// local files are deleted only if the whole session is safely stored
+	if config.DeleteLocal && report.Complete && data.Complete {
+		for i := range report.Files {
+			if err := os.Remove(dataFolder + report.Files[i].Path); err != nil {
+				logger.Error().Str("file", report.Files[i].Path).Err(err).Msg("local_file_delete_failed")
+			} else {
+				report.Files[i].Deleted = true
+			}
+		}
+	}
+
+	logger.Info().Bool("complete", report.Complete).Int("count", len(report.Files)).Msg("files_uploaded")
+	events.Publish(events.FilesUploaded, e.Namespace, e.RoomId, "", report)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/creamlab/ducksoup/events"
+)
+
+type fakeBackend struct {
+	uploaded map[string]File
+	failing  string
+}
+
+func (b *fakeBackend) Upload(ctx context.Context, f File) error {
+	if f.Key == b.failing {
+		return errors.New("upload failed")
+	}
+	b.uploaded[f.Key] = f
+	return nil
+}
+
+func (b *fakeBackend) Remove(ctx context.Context, key string) error {
+	delete(b.uploaded, key)
+	return nil
+}
+
+func TestUpload(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	config = storageConfig{Backend: "fake", DeleteLocal: true, MaxAttempts: 1}
+
+	os.MkdirAll("data/ns/logs", 0775)
+	os.WriteFile("data/ns/a-dry.mkv", []byte("abc"), 0664)
+	os.WriteFile("data/ns/m-manifest.json", []byte("{}"), 0664)
+	os.WriteFile("data/ns/logs/r.log", []byte("{}"), 0664)
+	data := events.FilesData{
+		Files:    map[string][]string{"u1": {"ns/a-dry.mkv"}},
+		Complete: true,
+		Manifest: "ns/m-manifest.json",
+		Log:      "ns/logs/r.log",
+	}
+	s := events.Subscribe(events.FilesUploaded)
+	defer s.Unsubscribe()
+
+	// failing upload: local files are kept
+	backend := &fakeBackend{uploaded: map[string]File{}, failing: "ns/logs/r.log"}
+	upload(backend, events.Event{Namespace: "ns", RoomId: "r"}, data)
+	report := (<-s.C).Data.(Report)
+	if report.Complete || len(report.Files) != 3 || report.Files[0].Deleted {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if f := backend.uploaded["ns/a-dry.mkv"]; f.Size != 3 || f.MD5 != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("unexpected file: %+v", f)
+	}
+
+	backend.failing = ""
+	upload(backend, events.Event{Namespace: "ns", RoomId: "r"}, data)
+	report = (<-s.C).Data.(Report)
+	if !report.Complete || !report.Files[2].Deleted {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, err := os.Stat("data/ns/a-dry.mkv"); !os.IsNotExist(err) {
+		t.Error("local file should be deleted")
+	}
+}
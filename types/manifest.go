@@ -0,0 +1,83 @@
+package types
+
+import "time"
+
+// Manifest describes a room session and its recordings, it is written as JSON
+// in the namespace folder once the room has ended
+type Manifest struct {
+	Namespace string          `json:"namespace"`
+	RoomId    string          `json:"roomId"`
+	Origin    string          `json:"origin"`
+	Size      int             `json:"size"`
+	Duration  int             `json:"duration"`
+	CreatedAt time.Time       `json:"createdAt"`
+	StartedAt time.Time       `json:"startedAt"`
+	EndedAt   time.Time       `json:"endedAt"`
+	Users     []ManifestUser  `json:"users"`
+	Files     []ManifestFile  `json:"files"`
+	Errors    []ManifestError `json:"errors"`
+	// relative to data folder, JSON-lines room log (complete once room is finalized)
+	LogFile string `json:"logFile,omitempty"`
+}
+
+type ManifestUser struct {
+	UserId            string               `json:"userId"`
+	JoinedCount       int                  `json:"joinedCount"`
+	ReconnectionCount int                  `json:"reconnectionCount"`
+	Connections       []ManifestConnection `json:"connections"`
+}
+
+// ManifestConnection is related to one peer connection (a user reconnecting gets a new one)
+type ManifestConnection struct {
+	Join        JoinPayload `json:"join"`
+	FilePrefix  string      `json:"filePrefix"`
+	ConnectedAt time.Time   `json:"connectedAt"`
+	// wall-clock time of pipeline start, matches the beginning of recordings
+	RecordingStartedAt time.Time         `json:"recordingStartedAt"`
+	Tracks             []ManifestTrack   `json:"tracks"`
+	FxEvents           []ManifestFxEvent `json:"fxEvents"`
+}
+
+type ManifestTrack struct {
+	Kind      string `json:"kind"`
+	Codec     string `json:"codec"`
+	ClockRate uint32 `json:"clockRate"`
+	SSRC      uint32 `json:"ssrc"`
+	// wall-clock to RTP time mapping given by the first received packet
+	FirstPacketAt     time.Time `json:"firstPacketAt"`
+	FirstRTPTimestamp uint32    `json:"firstRTPTimestamp"`
+	// sender (remote peer) NTP to RTP time mappings
+	SenderReports []ManifestSenderReport `json:"senderReports"`
+}
+
+// ManifestSenderReport is extracted from a RTCP sender report
+type ManifestSenderReport struct {
+	ReceivedAt   time.Time `json:"receivedAt"`
+	NTPTime      uint64    `json:"ntpTime"` // 32.32 fixed point, seconds since 1900
+	NTPWallClock time.Time `json:"ntpWallClock"`
+	RTPTimestamp uint32    `json:"rtpTimestamp"`
+}
+
+type ManifestFxEvent struct {
+	Time       time.Time `json:"time"`
+	SinceStart int64     `json:"sinceStart"` // ms, negative if room was not started
+	Name       string    `json:"name"`
+	Property   string    `json:"property"`
+	Value      string    `json:"value"`
+	Duration   int       `json:"duration"` // ms, interpolation duration
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"` // relative to data folder
+	UserId string `json:"userId,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type ManifestError struct {
+	Time     time.Time `json:"time"`
+	UserId   string    `json:"userId,omitempty"`
+	Pipeline string    `json:"pipeline,omitempty"`
+	Kind     string    `json:"kind,omitempty"` // error (GStreamer error or worker crash) or eos (unexpected end of stream)
+	Message  string    `json:"message"`
+}
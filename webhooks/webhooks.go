@@ -0,0 +1,167 @@
+// Package webhooks notifies an external HTTP endpoint of room lifecycle events
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+	"github.com/creamlab/ducksoup/helpers"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxAttempts    = 6
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	url            string
+	secret         string
+	initialBackoff = 1 * time.Second
+	kinds          = []events.Kind{
+		events.RoomCreated,
+		events.PeerJoined,
+		events.RoomStarted,
+		events.RoomEnded,
+		events.PeerDisconnected,
+		events.FilesReady,
+		events.FilesUploaded,
+		events.PipelineError,
+	}
+	client = &http.Client{Timeout: requestTimeout}
+)
+
+// Payload is sent as JSON, and its HMAC-SHA256 signature (keyed with DS_WEBHOOK_SECRET, see Sign)
+// is sent in the X-DuckSoup-Signature header
+type Payload struct {
+	Id        string      `json:"id"` // unique per event, same for all delivery attempts
+	Event     string      `json:"event"`
+	Time      time.Time   `json:"time"`
+	Namespace string      `json:"namespace"`
+	RoomId    string      `json:"roomId"`
+	UserId    string      `json:"userId,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+func init() {
+	url = helpers.Getenv("DS_WEBHOOK_URL")
+	secret = helpers.Getenv("DS_WEBHOOK_SECRET")
+	if envEvents := helpers.Getenv("DS_WEBHOOK_EVENTS"); len(envEvents) > 0 {
+		kinds = parseKinds(envEvents)
+	}
+}
+
+// comma separated list, blank entries are ignored
+func parseKinds(list string) (parsed []events.Kind) {
+	for _, kind := range strings.Split(list, ",") {
+		if kind = strings.TrimSpace(kind); len(kind) > 0 {
+			parsed = append(parsed, events.Kind(kind))
+		}
+	}
+	return
+}
+
+// Sign returns the signature of a request: the HMAC-SHA256 of its X-DuckSoup-Timestamp header value
+// (unix time in seconds), a dot and its body. Since the timestamp is signed, receivers may reject old
+// requests to prevent replays
+func Sign(timestamp string, body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start subscribes to events (if DS_WEBHOOK_URL is set) and delivers them asynchronously, retrying
+// with exponential backoff. Delivery order is not guaranteed, rely on payload time if needed
+func Start() {
+	if len(url) == 0 {
+		return
+	}
+	log.Info().Str("context", "init").Str("url", url).Interface("events", kinds).Msg("webhooks_enabled")
+
+	// deliveries are waited for on shutdown
+	s := events.SubscribeTracked(kinds...)
+	go func() {
+		for e := range s.C {
+			go func(p Payload) {
+				defer s.Done()
+				deliver(p)
+			}(Payload{
+				Id:        uuid.New().String(),
+				Event:     string(e.Kind),
+				Time:      e.Time,
+				Namespace: e.Namespace,
+				RoomId:    e.RoomId,
+				UserId:    e.UserId,
+				Data:      e.Data,
+			})
+		}
+	}()
+}
+
+func post(p Payload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-DuckSoup-Event", p.Event)
+	req.Header.Set("X-DuckSoup-Delivery", p.Id)
+	// set for each attempt, so that a retried request is not rejected as too old
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-DuckSoup-Timestamp", timestamp)
+	if len(secret) > 0 {
+		req.Header.Set("X-DuckSoup-Signature", Sign(timestamp, body, secret))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return nil
+}
+
+func deliver(p Payload) {
+	logger := log.With().
+		Str("context", "webhook").
+		Str("namespace", p.Namespace).
+		Str("room", p.RoomId).
+		Str("event", p.Event).
+		Str("delivery", p.Id).
+		Logger()
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		logger.Error().Err(err).Msg("webhook_failed")
+		return
+	}
+
+	backoff := initialBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = post(p, body)
+		if err == nil {
+			logger.Debug().Int("attempt", attempt).Msg("webhook_delivered")
+			return
+		}
+		logger.Info().Err(err).Int("attempt", attempt).Msg("webhook_attempt_failed")
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logger.Error().Err(err).Msg("webhook_failed")
+}
@@ -0,0 +1,65 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creamlab/ducksoup/events"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"room_created"}`)
+	// echo -n '1646128800.{"event":"room_created"}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=2a81f1b5e1b288eb97630752241734c24ff186e42e34d9b5022be3e660d6f9e2"
+	signature := Sign("1646128800", body, "secret")
+	if signature != expected {
+		t.Errorf("unexpected signature: %v", signature)
+	}
+	if signature == Sign("1646128801", body, "secret") {
+		t.Error("signature should depend on timestamp")
+	}
+	if signature == Sign("1646128800", body, "other") {
+		t.Error("signature should depend on secret")
+	}
+}
+
+func TestParseKinds(t *testing.T) {
+	parsed := parseKinds("room_created, files_ready,,")
+	if !reflect.DeepEqual(parsed, []events.Kind{events.RoomCreated, events.FilesReady}) {
+		t.Errorf("unexpected kinds: %v", parsed)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-DuckSoup-Timestamp")
+		if r.Header.Get("X-DuckSoup-Signature") != Sign(timestamp, body, "secret") {
+			t.Error("invalid signature")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	url, secret, initialBackoff = server.URL, "secret", time.Millisecond
+	defer func() {
+		url, secret, initialBackoff = "", "", time.Second
+	}()
+
+	deliver(Payload{Id: "id", Event: string(events.RoomCreated), Time: time.Now()})
+	if attempts != 3 {
+		t.Errorf("3 attempts expected, got %v", attempts)
+	}
+}